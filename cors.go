@@ -0,0 +1,231 @@
+package frodo
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures Cross-Origin Resource Sharing, see Router.CORS
+type CORSOptions struct {
+	// AllowOrigins lists the origins allowed, exactly, eg. "https://example.com",
+	// with a wildcard subdomain, eg. "https://*.example.com", or "*" for any.
+	// "*" cannot be used with AllowCredentials, any site could then act as the user.
+	AllowOrigins []string
+
+	// AllowOriginFunc decides for the origins AllowOrigins does not allow
+	AllowOriginFunc func(origin string, r *Request) bool
+
+	// AllowMethods lists the methods allowed, by default those a route is registered for on the path
+	AllowMethods []string
+
+	// AllowHeaders lists the request headers allowed, by default any the browser asks for
+	AllowHeaders []string
+
+	// ExposeHeaders lists the response headers the browser lets scripts read
+	ExposeHeaders []string
+
+	// AllowCredentials lets the browser send cookies and auth along
+	AllowCredentials bool
+
+	// MaxAge is how long the browser may cache the answer to a preflight request
+	MaxAge time.Duration
+}
+
+// cors applies CORSOptions to requests
+type cors struct {
+	opts      CORSOptions
+	anyOrigin bool
+}
+
+func newCORS(opts CORSOptions) *cors {
+	c := &cors{opts: opts}
+	for _, origin := range opts.AllowOrigins {
+		if origin == "*" {
+			c.anyOrigin = true
+		}
+	}
+	if c.anyOrigin && opts.AllowCredentials {
+		panic("Error: CORS cannot allow credentials from any origin \"*\", list the origins allowed instead")
+	}
+	return c
+}
+
+// CORS answers cross-origin requests for every route of the Router. Preflight OPTIONS
+// requests are answered for the paths that have no OPTIONS route of their own, with
+// the methods routes are registered for on the path unless AllowMethods says otherwise.
+//
+//	router.CORS(frodo.CORSOptions{
+//		AllowOrigins:     []string{"https://example.com", "https://*.example.com"},
+//		AllowCredentials: true,
+//		MaxAge:           time.Hour,
+//	})
+func (r *Router) CORS(opts CORSOptions) {
+	r.cors = newCORS(opts)
+}
+
+// CORS is middleware answering cross-origin requests for the routes it is used on,
+// including preflight requests when it is on an OPTIONS route. See Router.CORS to
+// cover every route at once.
+func CORS(opts CORSOptions) Handler {
+	c := newCORS(opts)
+	return func(w http.ResponseWriter, r *Request) {
+		if c.isPreflight(r) {
+			c.preflight(w, r)
+			return
+		}
+		c.actual(w, r)
+		r.Next()
+	}
+}
+
+// isPreflight checks if the request is the browser asking before the real request
+func (c *cors) isPreflight(r *Request) bool {
+	return r.Method == "OPTIONS" && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// allowOrigin checks the origin against the options
+func (c *cors) allowOrigin(origin string, r *Request) bool {
+	if origin == "" {
+		return false
+	}
+	for _, allowed := range c.opts.AllowOrigins {
+		if matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+	return c.opts.AllowOriginFunc != nil && c.opts.AllowOriginFunc(origin, r)
+}
+
+// matchOrigin checks the origin against an allowed one, exact, with
+// a wildcard subdomain, eg. "https://*.example.com", or "*" for any
+func matchOrigin(allowed, origin string) bool {
+	if allowed == "*" || strings.EqualFold(allowed, origin) {
+		return true
+	}
+	if prefix, suffix, wildcard := strings.Cut(strings.ToLower(allowed), "*"); wildcard {
+		lower := strings.ToLower(origin)
+		return len(lower) > len(prefix)+len(suffix) && strings.HasPrefix(lower, prefix) && strings.HasSuffix(lower, suffix) &&
+			!strings.ContainsAny(lower[len(prefix):len(lower)-len(suffix)], "/:")
+	}
+	return false
+}
+
+// allowOriginHeaders sets the headers naming the origin allowed, reporting false when it is not
+func (c *cors) allowOriginHeaders(header http.Header, r *Request) bool {
+	origin := r.Header.Get("Origin")
+	if !c.anyOrigin {
+		// the answer depends on who is asking
+		header.Add("Vary", "Origin")
+	}
+	if !c.allowOrigin(origin, r) {
+		return false
+	}
+
+	if c.anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.opts.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// actual adds the CORS headers to a request that is not a preflight
+func (c *cors) actual(w http.ResponseWriter, r *Request) {
+	header := w.Header()
+	if c.allowOriginHeaders(header, r) && len(c.opts.ExposeHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(c.opts.ExposeHeaders, ", "))
+	}
+}
+
+// preflight answers a preflight request with what the route allows
+func (c *cors) preflight(w http.ResponseWriter, r *Request) {
+	header := w.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	methods := c.opts.AllowMethods
+	if len(methods) == 0 && r.router != nil {
+		methods = r.router.allowedMethods(r.URL.Path)
+		if len(methods) == 0 {
+			r.router.notFound(w, r)
+			return
+		}
+	}
+
+	if !c.allowOriginHeaders(header, r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	requested := strings.ToUpper(strings.TrimSpace(r.Header.Get("Access-Control-Request-Method")))
+	if !containsFold(methods, requested) {
+		// without the methods the browser refuses the request
+		header.Del("Access-Control-Allow-Origin")
+		header.Del("Access-Control-Allow-Credentials")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+	if requestedHeaders := r.Header.Get("Access-Control-Request-Headers"); requestedHeaders != "" {
+		if len(c.opts.AllowHeaders) == 0 {
+			header.Set("Access-Control-Allow-Headers", requestedHeaders)
+		} else {
+			for _, name := range strings.Split(requestedHeaders, ",") {
+				if !containsFold(c.opts.AllowHeaders, strings.TrimSpace(name)) {
+					header.Del("Access-Control-Allow-Origin")
+					header.Del("Access-Control-Allow-Credentials")
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+			header.Set("Access-Control-Allow-Headers", strings.Join(c.opts.AllowHeaders, ", "))
+		}
+	}
+
+	if c.opts.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(c.opts.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowedMethods lists the methods routes are registered for on the path
+func (r *Router) allowedMethods(path string) []string {
+	var methods []string
+	for method := range r.trees {
+		if r.hasRoute(method, path) {
+			methods = append(methods, method)
+		}
+	}
+	if len(methods) > 0 && !containsFold(methods, "OPTIONS") {
+		methods = append(methods, "OPTIONS")
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// hasRoute checks if a route is registered for the method on the path
+func (r *Router) hasRoute(method, path string) bool {
+	root := r.trees[method]
+	if root == nil {
+		return false
+	}
+	handlers, _, _, _ := root.getValue(path)
+	return len(handlers) > 0
+}
+
+// containsFold checks if the list holds the value, ignoring case
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package frodo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		allowed, origin string
+		want            bool
+	}{
+		{"*", "https://anything.com", true},
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "HTTPS://Example.COM", true},
+		{"https://example.com", "http://example.com", false},
+		{"https://example.com", "https://example.com:8443", false},
+		{"https://example.com:8443", "https://example.com:8443", true},
+		{"https://example.com", "https://example.com.evil.com", false},
+
+		{"https://*.example.com", "https://api.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://API.Example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://.example.com", false},
+		{"https://*.example.com", "https://evil.com", false},
+		{"https://*.example.com", "https://evilexample.com", false},
+		{"https://*.example.com", "https://api.example.com.evil.com", false},
+		{"https://*.example.com", "http://api.example.com", false},
+		{"https://*.example.com", "https://api.example.com:8443", false},
+		{"https://*.example.com", "https://evil.com:1/.example.com", false},
+		{"https://*.example.com", "https://evil.com/.example.com", false},
+		{"https://*.example.com:8443", "https://api.example.com:8443", true},
+		{"https://*.example.com:8443", "https://api.example.com", false},
+	}
+	for _, test := range tests {
+		if got := matchOrigin(test.allowed, test.origin); got != test.want {
+			t.Errorf("matchOrigin(%q, %q) = %v, want %v", test.allowed, test.origin, got, test.want)
+		}
+	}
+}
+
+func TestCORSAnyOriginWithCredentials(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("allowing credentials from any origin did not panic")
+		}
+	}()
+	New().CORS(CORSOptions{AllowOrigins: []string{"*"}, AllowCredentials: true})
+}
+
+func TestCORSPreflight(t *testing.T) {
+	newApp := func(opts CORSOptions) *Router {
+		app := New()
+		app.CORS(opts)
+		app.Get("/items", func(w http.ResponseWriter, r *Request) {})
+		app.Post("/items", func(w http.ResponseWriter, r *Request) {})
+		app.Delete("/items/:id", func(w http.ResponseWriter, r *Request) {})
+		app.Options("/own", func(w http.ResponseWriter, r *Request) {
+			w.Write([]byte("own"))
+		})
+		return app
+	}
+	credentials := CORSOptions{
+		AllowOrigins:     []string{"https://example.com", "https://*.example.com"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	}
+
+	tests := []struct {
+		name    string
+		opts    CORSOptions
+		path    string
+		origin  string
+		method  string
+		headers string
+		status  int
+		want    map[string]string
+	}{
+		{"registered route", credentials, "/items", "https://api.example.com", "POST", "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":      "https://api.example.com",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Allow-Methods":     "GET, OPTIONS, POST",
+			"Access-Control-Max-Age":           "3600",
+		}},
+		{"route with params", credentials, "/items/42", "https://example.com", "DELETE", "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":  "https://example.com",
+			"Access-Control-Allow-Methods": "DELETE, OPTIONS",
+		}},
+		{"headers asked for", credentials, "/items", "https://example.com", "POST", "Content-Type, X-Token", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":  "https://example.com",
+			"Access-Control-Allow-Headers": "Content-Type, X-Token",
+		}},
+		{"method not registered", credentials, "/items", "https://example.com", "PUT", "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":  "",
+			"Access-Control-Allow-Methods": "",
+		}},
+		{"origin not allowed", credentials, "/items", "https://evil.com", "POST", "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":      "",
+			"Access-Control-Allow-Credentials": "",
+		}},
+		{"no route", credentials, "/missing", "https://example.com", "GET", "", http.StatusNotFound, map[string]string{
+			"Access-Control-Allow-Origin": "",
+		}},
+		{"own OPTIONS route", credentials, "/own", "https://example.com", "GET", "", http.StatusOK, map[string]string{
+			"Access-Control-Allow-Methods": "",
+		}},
+
+		{"any origin", CORSOptions{AllowOrigins: []string{"*"}}, "/items", "https://evil.com", "GET", "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":      "*",
+			"Access-Control-Allow-Credentials": "",
+		}},
+		{"methods given", CORSOptions{AllowOrigins: []string{"*"}, AllowMethods: []string{"GET", "PUT"}}, "/missing", "https://a.com", "PUT", "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":  "*",
+			"Access-Control-Allow-Methods": "GET, PUT",
+		}},
+		{"headers given", CORSOptions{AllowOrigins: []string{"*"}, AllowHeaders: []string{"Content-Type"}}, "/items", "https://a.com", "POST", "content-type", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":  "*",
+			"Access-Control-Allow-Headers": "Content-Type",
+		}},
+		{"header not given", CORSOptions{AllowOrigins: []string{"*"}, AllowHeaders: []string{"Content-Type"}}, "/items", "https://a.com", "POST", "X-Token", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin":  "",
+			"Access-Control-Allow-Headers": "",
+		}},
+		{"origin func", CORSOptions{AllowOriginFunc: func(origin string, r *Request) bool {
+			return origin == "https://partner.com"
+		}}, "/items", "https://partner.com", "GET", "", http.StatusNoContent, map[string]string{
+			"Access-Control-Allow-Origin": "https://partner.com",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("OPTIONS", test.path, nil)
+			req.Header.Set("Origin", test.origin)
+			req.Header.Set("Access-Control-Request-Method", test.method)
+			if test.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", test.headers)
+			}
+			rec := httptest.NewRecorder()
+			newApp(test.opts).ServeHTTP(rec, req)
+
+			if rec.Code != test.status {
+				t.Errorf("answered with %d, want %d", rec.Code, test.status)
+			}
+			for name, want := range test.want {
+				if got := rec.Header().Get(name); got != want {
+					t.Errorf("%s is %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	app := New()
+	app.CORS(CORSOptions{
+		AllowOrigins:     []string{"https://*.example.com"},
+		AllowCredentials: true,
+		ExposeHeaders:    []string{"X-Total"},
+	})
+	app.Get("/items", func(w http.ResponseWriter, r *Request) {
+		w.Write([]byte("items"))
+	})
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://api.example.com", true},
+		{"https://evil.com", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/items", nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK || rec.Body.String() != "items" {
+			t.Errorf("origin %q got %d %q", test.origin, rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get("Vary") != "Origin" {
+			t.Errorf("origin %q answered without Vary: Origin", test.origin)
+		}
+		allowOrigin, expose := "", ""
+		if test.allowed {
+			allowOrigin, expose = test.origin, "X-Total"
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != allowOrigin {
+			t.Errorf("origin %q is allowed %q", test.origin, got)
+		}
+		if got := rec.Header().Get("Access-Control-Expose-Headers"); got != expose {
+			t.Errorf("origin %q is exposed %q", test.origin, got)
+		}
+	}
+}
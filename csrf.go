@@ -0,0 +1,504 @@
+package frodo
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CSRFHeaderName is the request header a CSRF token can be sent in, eg. by scripts
+const CSRFHeaderName = "X-CSRF-Token"
+
+// csrfTokenSize is the number of random bytes in a token
+const csrfTokenSize = 32
+
+// the reasons a request fails the CSRF check, see Request.CSRFError
+var (
+	ErrCSRFTokenMissing = errors.New("frodo: CSRF token missing")
+	ErrCSRFTokenInvalid = errors.New("frodo: CSRF token invalid")
+	ErrCSRFOrigin       = errors.New("frodo: request from an untrusted origin")
+)
+
+// CSRFOptions configures the protection against Cross-Site Request Forgery, see Router.CSRF
+type CSRFOptions struct {
+	// Store keeps the token of every client, a CSRFCookieStore if it is not set
+	Store CSRFStore
+
+	// FieldName is the form field the token is looked for in, CSRFFieldName by default.
+	// The CSRFHeaderName header is looked at first.
+	FieldName string
+
+	// ExemptRoutes are the names of the routes left unchecked, eg. webhooks
+	ExemptRoutes []string
+
+	// ExemptPrefixes are the paths left unchecked, eg. "/api/"
+	ExemptPrefixes []string
+
+	// Exempt decides for the other requests whether to leave them unchecked
+	Exempt func(r *Request) bool
+
+	// TrustedOrigins are the origins other than the application's own that may send
+	// unsafe requests, exact or with a wildcard subdomain, eg. "https://*.example.com"
+	TrustedOrigins []string
+
+	// RotatePerRequest issues a new token after every unsafe request checked,
+	// pages open in other tabs then fail the check
+	RotatePerRequest bool
+
+	// ErrorHandler answers the requests that fail the check, a plain 403 Forbidden
+	// by default. Request.CSRFError tells why it failed.
+	ErrorHandler Handler
+}
+
+// CSRFStore keeps the CSRF token issued to each client
+type CSRFStore interface {
+	// Load returns the token issued to the client, empty if there is none
+	Load(r *Request) (string, error)
+
+	// Save issues the token to the client, before the response is written
+	Save(w http.ResponseWriter, r *Request, token string) error
+}
+
+// csrf applies CSRFOptions to requests
+type csrf struct {
+	opts CSRFOptions
+}
+
+func newCSRF(opts CSRFOptions) *csrf {
+	if opts.Store == nil {
+		opts.Store = &CSRFCookieStore{}
+	}
+	if opts.FieldName == "" {
+		opts.FieldName = CSRFFieldName
+	}
+	return &csrf{opts: opts}
+}
+
+// CSRF checks every POST, PUT, PATCH and DELETE request to the Router's routes carries
+// the client's token, and comes from the application's own origin. Safe methods
+// are left alone. Templates put the token in forms with the csrfField helper,
+// scripts send it in the X-CSRF-Token header, see Request.CSRFToken.
+//
+//	router.CSRF(frodo.CSRFOptions{
+//		Store:        &frodo.CSRFCookieStore{Secret: secret},
+//		ExemptRoutes: []string{"stripe.webhook"},
+//	})
+//
+// The request body is parsed to read the form field, a route streaming a
+// multipart body through Request.Multipart has the token sent in the header.
+func (r *Router) CSRF(opts CSRFOptions) {
+	r.csrf = newCSRF(opts)
+}
+
+// CSRF is middleware protecting the routes it is used on against Cross-Site
+// Request Forgery, see Router.CSRF to cover every route at once
+func CSRF(opts CSRFOptions) Handler {
+	c := newCSRF(opts)
+	return func(w http.ResponseWriter, r *Request) {
+		if c.check(w, r) {
+			r.Next()
+		}
+	}
+}
+
+// check verifies the request, answering it when it fails
+func (c *csrf) check(w http.ResponseWriter, r *Request) bool {
+	r.csrf = c
+	if !c.unsafe(r) || c.exempt(r) {
+		return true
+	}
+
+	if err := c.verify(r); err != nil {
+		r.csrfErr = err
+		if c.opts.ErrorHandler != nil {
+			c.opts.ErrorHandler(w, r)
+		} else {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		}
+		return false
+	}
+
+	if c.opts.RotatePerRequest {
+		if err := r.RotateCSRFToken(); err != nil {
+			logf(r.writer.logger, "[ERROR] Rotating the CSRF token: %s", err)
+		}
+	}
+	return true
+}
+
+// unsafe checks if the method changes state, the only requests a forgery matters for
+func (c *csrf) unsafe(r *Request) bool {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return false
+	}
+	return true
+}
+
+// exempt checks if the request is left unchecked
+func (c *csrf) exempt(r *Request) bool {
+	if name := r.RouteName(); name != "" && containsFold(c.opts.ExemptRoutes, name) {
+		return true
+	}
+	for _, prefix := range c.opts.ExemptPrefixes {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return c.opts.Exempt != nil && c.opts.Exempt(r)
+}
+
+// verify checks the origin of the request, then the token it carries
+func (c *csrf) verify(r *Request) error {
+	if err := c.verifyOrigin(r); err != nil {
+		return err
+	}
+
+	expected, err := c.opts.Store.Load(r)
+	if err != nil || expected == "" {
+		return ErrCSRFTokenMissing
+	}
+
+	sent := r.Header.Get(CSRFHeaderName)
+	if sent == "" {
+		// only bodies that are forms, others are left for the handler to read
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data" {
+			sent = r.FormValue(c.opts.FieldName)
+		}
+	}
+	if sent == "" {
+		return ErrCSRFTokenMissing
+	}
+
+	token, ok := unmaskCSRFToken(sent)
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		return ErrCSRFTokenInvalid
+	}
+	r.csrfToken = expected
+	return nil
+}
+
+// verifyOrigin checks the Origin header, or the Referer when there is none, names the
+// application's own host or a trusted origin. Browsers send no Referer over HTTPS
+// only when told not to, which is then refused.
+func (c *csrf) verifyOrigin(r *Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer := r.Referer()
+		if referer == "" {
+			if r.Scheme() == "https" {
+				return ErrCSRFOrigin
+			}
+			return nil
+		}
+		u, err := url.Parse(referer)
+		if err != nil || u.Host == "" {
+			return ErrCSRFOrigin
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+	if origin == "null" {
+		return ErrCSRFOrigin
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return ErrCSRFOrigin
+	}
+	if strings.EqualFold(u.Host, r.ForwardedHost()) && (r.Scheme() == "http" || u.Scheme == "https") {
+		return nil
+	}
+	for _, trusted := range c.opts.TrustedOrigins {
+		if matchOrigin(trusted, origin) {
+			return nil
+		}
+	}
+	return ErrCSRFOrigin
+}
+
+// CSRFToken returns the token to send back along with unsafe requests, issuing one
+// to the client the first time. It is masked differently on every call, so it
+// cannot be worked out from compressed responses (BREACH), any of them is valid.
+// It is empty when the request is not protected by CSRF.
+//
+//	<meta name="csrf-token" content="{{ csrfToken }}">
+func (r *Request) CSRFToken() string {
+	if r.csrf == nil {
+		return ""
+	}
+	if r.csrfToken == "" {
+		token, err := r.csrf.opts.Store.Load(r)
+		if err != nil || token == "" {
+			if err := r.RotateCSRFToken(); err != nil {
+				logf(r.writer.logger, "[ERROR] Issuing a CSRF token: %s", err)
+				return ""
+			}
+			token = r.csrfToken
+		}
+		r.csrfToken = token
+	}
+	return maskCSRFToken(r.csrfToken)
+}
+
+// RotateCSRFToken issues the client a new token, the previous one no longer
+// passes the check. It is advised on login and logout.
+func (r *Request) RotateCSRFToken() error {
+	if r.csrf == nil {
+		return errors.New("frodo: the request is not protected by CSRF")
+	}
+	token, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+	if err := r.csrf.opts.Store.Save(r.writer, r, token); err != nil {
+		return err
+	}
+	r.csrfToken = token
+	return nil
+}
+
+// CSRFError tells why the request failed the CSRF check, nil if it did not
+func (r *Request) CSRFError() error {
+	return r.csrfErr
+}
+
+// newCSRFToken returns a new random token
+func newCSRFToken() (string, error) {
+	b := make([]byte, csrfTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// maskCSRFToken XORs the token with a random pad, sending the pad along
+func maskCSRFToken(token string) string {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return ""
+	}
+	masked := make([]byte, 2*len(raw))
+	if _, err := rand.Read(masked[:len(raw)]); err != nil {
+		return ""
+	}
+	for i := range raw {
+		masked[len(raw)+i] = masked[i] ^ raw[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+// unmaskCSRFToken reverses maskCSRFToken. Unmasked tokens are taken as they are,
+// scripts may send back the one read from a CSRFCookieStore's cookie.
+func unmaskCSRFToken(sent string) (string, bool) {
+	sent, _, _ = strings.Cut(sent, ".")
+	raw, err := base64.RawURLEncoding.DecodeString(sent)
+	if err != nil {
+		return "", false
+	}
+	switch len(raw) {
+	case csrfTokenSize:
+		return sent, true
+	case 2 * csrfTokenSize:
+		token := make([]byte, csrfTokenSize)
+		for i := range token {
+			token[i] = raw[i] ^ raw[csrfTokenSize+i]
+		}
+		return base64.RawURLEncoding.EncodeToString(token), true
+	}
+	return "", false
+}
+
+// CSRFCookieStore keeps the token in a cookie, the double submit pattern: a forged
+// request carries the cookie but cannot read it to put it in the form as well.
+// Signing it with a Secret keeps a subdomain from planting a token of its own.
+type CSRFCookieStore struct {
+	// Secret signs the cookie, it should be at least 32 random bytes and
+	// the same across restarts and instances
+	Secret []byte
+
+	// Name is the name of the cookie, "_csrf" by default
+	Name string
+
+	// Path and Domain scope the cookie, Path is "/" by default
+	Path   string
+	Domain string
+
+	// MaxAge is how long the cookie lasts, until the browser is closed if zero
+	MaxAge time.Duration
+
+	// Secure sends the cookie over HTTPS only, it always is for requests made over HTTPS
+	Secure bool
+
+	// SameSite is http.SameSiteLaxMode by default
+	SameSite http.SameSite
+
+	// Readable lets scripts read the cookie to send the token in the X-CSRF-Token header
+	Readable bool
+}
+
+func (s *CSRFCookieStore) name() string {
+	if s.Name == "" {
+		return CSRFFieldName
+	}
+	return s.Name
+}
+
+// Load reads the token from the cookie, verifying its signature
+func (s *CSRFCookieStore) Load(r *Request) (string, error) {
+	cookie, err := r.Cookie(s.name())
+	if err != nil {
+		return "", nil
+	}
+	token, signature, signed := strings.Cut(cookie.Value, ".")
+	if len(s.Secret) > 0 {
+		if !signed || !hmac.Equal([]byte(signature), []byte(s.sign(token))) {
+			return "", ErrCSRFTokenInvalid
+		}
+	}
+	return token, nil
+}
+
+// Save sets the cookie
+func (s *CSRFCookieStore) Save(w http.ResponseWriter, r *Request, token string) error {
+	value := token
+	if len(s.Secret) > 0 {
+		value += "." + s.sign(token)
+	}
+	cookie := &http.Cookie{
+		Name:     s.name(),
+		Value:    value,
+		Path:     s.Path,
+		Domain:   s.Domain,
+		Secure:   s.Secure || r.Scheme() == "https",
+		HttpOnly: !s.Readable,
+		SameSite: s.SameSite,
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	if cookie.SameSite == 0 {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	if s.MaxAge > 0 {
+		cookie.MaxAge = int(s.MaxAge.Seconds())
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+func (s *CSRFCookieStore) sign(token string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DefaultCSRFTokenTTL is how long a CSRFMemoryStore keeps an unused token
+const DefaultCSRFTokenTTL = 12 * time.Hour
+
+// CSRFMemoryStore keeps the tokens on the server, the synchronizer token pattern.
+// The client only holds a random id in a cookie. The tokens are lost on restart
+// and not shared between instances, see CSRFSessionStore for one that can be.
+type CSRFMemoryStore struct {
+	// TTL is how long a token is kept since it was last used, DefaultCSRFTokenTTL if zero
+	TTL time.Duration
+
+	// Cookie is the name of the cookie holding the client's id, "_csrf_id" by default
+	Cookie string
+
+	mu        sync.Mutex
+	tokens    map[string]csrfMemoryToken
+	lastSweep time.Time
+}
+
+type csrfMemoryToken struct {
+	token   string
+	expires time.Time
+}
+
+// NewCSRFMemoryStore returns a store keeping the tokens for the time given since they were
+// last used, DefaultCSRFTokenTTL if zero
+func NewCSRFMemoryStore(ttl time.Duration) *CSRFMemoryStore {
+	return &CSRFMemoryStore{TTL: ttl}
+}
+
+func (s *CSRFMemoryStore) ttl() time.Duration {
+	if s.TTL <= 0 {
+		return DefaultCSRFTokenTTL
+	}
+	return s.TTL
+}
+
+func (s *CSRFMemoryStore) cookie() string {
+	if s.Cookie == "" {
+		return "_csrf_id"
+	}
+	return s.Cookie
+}
+
+// Load returns the token kept for the client's id
+func (s *CSRFMemoryStore) Load(r *Request) (string, error) {
+	cookie, err := r.Cookie(s.cookie())
+	if err != nil {
+		return "", nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.tokens[cookie.Value]
+	if !ok || time.Now().After(stored.expires) {
+		return "", nil
+	}
+	stored.expires = time.Now().Add(s.ttl())
+	s.tokens[cookie.Value] = stored
+	return stored.token, nil
+}
+
+// Save keeps the token under the client's id, giving the client one if it has none
+func (s *CSRFMemoryStore) Save(w http.ResponseWriter, r *Request, token string) error {
+	id := ""
+	if cookie, err := r.Cookie(s.cookie()); err == nil {
+		id = cookie.Value
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tokens[id]; !ok {
+		var err error
+		if id, err = newCSRFToken(); err != nil {
+			return err
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     s.cookie(),
+			Value:    id,
+			Path:     "/",
+			Secure:   r.Scheme() == "https",
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	now := time.Now()
+	if s.tokens == nil {
+		s.tokens = make(map[string]csrfMemoryToken)
+	}
+	if now.Sub(s.lastSweep) > s.ttl() {
+		for key, stored := range s.tokens {
+			if now.After(stored.expires) {
+				delete(s.tokens, key)
+			}
+		}
+		s.lastSweep = now
+	}
+	s.tokens[id] = csrfMemoryToken{token: token, expires: now.Add(s.ttl())}
+	return nil
+}
@@ -0,0 +1,239 @@
+package frodo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// csrfApp serves a form issuing the token and a route it is posted to,
+// answering failed checks with the reason
+func csrfApp(store CSRFStore) *Router {
+	app := New()
+	app.TrustedProxies = []string{"loopback"}
+	app.CSRF(CSRFOptions{
+		Store:          store,
+		ExemptRoutes:   []string{"webhook"},
+		TrustedOrigins: []string{"https://*.example.org"},
+		ErrorHandler: func(w http.ResponseWriter, r *Request) {
+			http.Error(w, r.CSRFError().Error(), http.StatusForbidden)
+		},
+	})
+	app.Get("/form", func(w http.ResponseWriter, r *Request) {
+		w.Write([]byte(r.CSRFToken()))
+	})
+	app.Post("/submit", func(w http.ResponseWriter, r *Request) {
+		w.Write([]byte("ok"))
+	})
+	app.Post("/webhook", Attributes{Name: "webhook"}, func(w http.ResponseWriter, r *Request) {
+		w.Write([]byte("ok"))
+	})
+	return app
+}
+
+// issueCSRFToken renders the form, returning the token and the cookies it came with
+func issueCSRFToken(t *testing.T, app *Router, target string) (string, []*http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Fatalf("rendering the form got %d %q", rec.Code, rec.Body.String())
+	}
+	return rec.Body.String(), rec.Result().Cookies()
+}
+
+func TestCSRFTokenMasking(t *testing.T) {
+	app := New()
+	app.CSRF(CSRFOptions{Store: &CSRFCookieStore{Secret: []byte("secret")}})
+	app.Get("/form", func(w http.ResponseWriter, r *Request) {
+		// the same token, masked anew on every render
+		w.Write([]byte(r.CSRFToken() + " " + r.CSRFToken()))
+	})
+	rec := httptest.NewRecorder()
+	app.ServeHTTP(rec, httptest.NewRequest("GET", "/form", nil))
+	first, second, _ := strings.Cut(rec.Body.String(), " ")
+	if first == "" || first == second {
+		t.Fatalf("rendered %q and %q", first, second)
+	}
+
+	a, okA := unmaskCSRFToken(first)
+	b, okB := unmaskCSRFToken(second)
+	if !okA || !okB || a != b {
+		t.Fatalf("unmasked to %q and %q", a, b)
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || !strings.HasPrefix(cookies[0].Value, a+".") {
+		t.Fatalf("the cookie does not hold the token: %v", cookies)
+	}
+	if masked := maskCSRFToken(a); masked == first || masked == second {
+		t.Fatal("masking the token twice gave the same value")
+	}
+	if _, ok := unmaskCSRFToken("not-a-token"); ok {
+		t.Fatal("unmasked a value of the wrong size")
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func() CSRFStore
+	}{
+		{"signed cookie", func() CSRFStore { return &CSRFCookieStore{Secret: []byte("secret")} }},
+		{"cookie", func() CSRFStore { return &CSRFCookieStore{} }},
+		{"memory", func() CSRFStore { return NewCSRFMemoryStore(0) }},
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		path    string
+		token   string // "valid" for the one issued, "form" for it in the form field
+		header  map[string]string
+		cookies bool
+		want    string
+	}{
+		{"header token", "http://example.com", "/submit", "valid", nil, true, "ok"},
+		{"form token", "http://example.com", "/submit", "form", nil, true, "ok"},
+		{"missing token", "http://example.com", "/submit", "", nil, true, ErrCSRFTokenMissing.Error()},
+		{"missing cookie", "http://example.com", "/submit", "valid", nil, false, ErrCSRFTokenMissing.Error()},
+		{"other token", "http://example.com", "/submit", "other", nil, true, ErrCSRFTokenInvalid.Error()},
+		{"garbage token", "http://example.com", "/submit", "%%%", nil, true, ErrCSRFTokenInvalid.Error()},
+		{"exempt route", "http://example.com", "/webhook", "", nil, false, "ok"},
+
+		{"same origin", "http://example.com", "/submit", "valid",
+			map[string]string{"Origin": "http://example.com"}, true, "ok"},
+		{"cross origin", "http://example.com", "/submit", "valid",
+			map[string]string{"Origin": "https://evil.com"}, true, ErrCSRFOrigin.Error()},
+		{"cross origin without a token", "http://example.com", "/submit", "",
+			map[string]string{"Origin": "https://evil.com"}, true, ErrCSRFOrigin.Error()},
+		{"null origin", "http://example.com", "/submit", "valid",
+			map[string]string{"Origin": "null"}, true, ErrCSRFOrigin.Error()},
+		{"trusted origin", "http://example.com", "/submit", "valid",
+			map[string]string{"Origin": "https://shop.example.org"}, true, "ok"},
+		{"lookalike of a trusted origin", "http://example.com", "/submit", "valid",
+			map[string]string{"Origin": "https://shop.example.org.evil.com"}, true, ErrCSRFOrigin.Error()},
+		{"cross origin referer", "http://example.com", "/submit", "valid",
+			map[string]string{"Referer": "http://evil.com/page"}, true, ErrCSRFOrigin.Error()},
+
+		{"HTTPS same origin referer", "https://example.com", "/submit", "valid",
+			map[string]string{"Referer": "https://example.com/form"}, true, "ok"},
+		{"HTTPS without a referer", "https://example.com", "/submit", "valid", nil, true, ErrCSRFOrigin.Error()},
+		{"HTTPS with an HTTP referer", "https://example.com", "/submit", "valid",
+			map[string]string{"Referer": "http://example.com/form"}, true, ErrCSRFOrigin.Error()},
+		{"HTTPS with an HTTP origin", "https://example.com", "/submit", "valid",
+			map[string]string{"Origin": "http://example.com"}, true, ErrCSRFOrigin.Error()},
+		{"HTTPS behind a proxy without a referer", "http://example.com", "/submit", "valid",
+			map[string]string{"X-Forwarded-Proto": "https"}, true, ErrCSRFOrigin.Error()},
+		{"HTTPS behind a proxy with a referer", "http://example.com", "/submit", "valid",
+			map[string]string{"X-Forwarded-Proto": "https", "Referer": "https://example.com/form"}, true, "ok"},
+	}
+
+	for _, store := range stores {
+		for _, test := range tests {
+			t.Run(store.name+"/"+test.name, func(t *testing.T) {
+				app := csrfApp(store.store())
+				token, cookies := issueCSRFToken(t, app, test.target+"/form")
+
+				var body string
+				sent := ""
+				switch test.token {
+				case "valid":
+					sent = token
+				case "form":
+					body = url.Values{CSRFFieldName: {token}}.Encode()
+				case "other":
+					other, _ := newCSRFToken()
+					sent = maskCSRFToken(other)
+				default:
+					sent = test.token
+				}
+
+				req := httptest.NewRequest("POST", test.target+test.path, strings.NewReader(body))
+				req.RemoteAddr = "127.0.0.1:1234"
+				if body != "" {
+					req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				}
+				if sent != "" {
+					req.Header.Set(CSRFHeaderName, sent)
+				}
+				for name, value := range test.header {
+					req.Header.Set(name, value)
+				}
+				if test.cookies {
+					for _, cookie := range cookies {
+						req.AddCookie(cookie)
+					}
+				}
+
+				rec := httptest.NewRecorder()
+				app.ServeHTTP(rec, req)
+				if got := strings.TrimSpace(rec.Body.String()); got != test.want {
+					t.Errorf("got %d %q, want %q", rec.Code, got, test.want)
+				}
+				if test.want != "ok" && rec.Code != http.StatusForbidden {
+					t.Errorf("a failed check was answered with %d", rec.Code)
+				}
+			})
+		}
+	}
+}
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret []byte
+		plant  func(cookie string) (string, string) // the cookie and the header sent
+		want   int
+	}{
+		{"cookie read by a script", []byte("secret"), func(cookie string) (string, string) {
+			return cookie, cookie
+		}, http.StatusOK},
+		{"unsigned cookie read by a script", nil, func(cookie string) (string, string) {
+			return cookie, cookie
+		}, http.StatusOK},
+		{"header not matching the cookie", []byte("secret"), func(cookie string) (string, string) {
+			other, _ := newCSRFToken()
+			return cookie, other
+		}, http.StatusForbidden},
+		{"cookie planted without the signature", []byte("secret"), func(string) (string, string) {
+			planted, _ := newCSRFToken()
+			return planted, planted
+		}, http.StatusForbidden},
+		{"cookie planted with a forged signature", []byte("secret"), func(string) (string, string) {
+			planted, _ := newCSRFToken()
+			forged := (&CSRFCookieStore{Secret: []byte("guess")}).sign(planted)
+			return planted + "." + forged, planted
+		}, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &CSRFCookieStore{Secret: test.secret, Readable: true}
+			app := csrfApp(store)
+			_, cookies := issueCSRFToken(t, app, "/form")
+			if len(cookies) != 1 || cookies[0].Name != CSRFFieldName || cookies[0].HttpOnly {
+				t.Fatalf("issued the cookies %v", cookies)
+			}
+
+			cookie, header := test.plant(cookies[0].Value)
+			req := httptest.NewRequest("POST", "/submit", nil)
+			req.Header.Set(CSRFHeaderName, header)
+			req.AddCookie(&http.Cookie{Name: CSRFFieldName, Value: cookie})
+			rec := httptest.NewRecorder()
+			app.ServeHTTP(rec, req)
+			if rec.Code != test.want {
+				t.Errorf("got %d %q, want %d", rec.Code, rec.Body.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestCSRFCookieSecure(t *testing.T) {
+	app := csrfApp(&CSRFCookieStore{})
+	if _, cookies := issueCSRFToken(t, app, "http://example.com/form"); len(cookies) != 1 || cookies[0].Secure {
+		t.Errorf("over HTTP issued %v", cookies)
+	}
+	if _, cookies := issueCSRFToken(t, app, "https://example.com/form"); len(cookies) != 1 || !cookies[0].Secure {
+		t.Errorf("over HTTPS issued %v", cookies)
+	}
+}
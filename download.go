@@ -0,0 +1,194 @@
+package frodo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File sends the file at the path, letting the browser display it if it can.
+// Byte ranges, including multipart/byteranges, and conditional requests are
+// answered by http.ServeContent. When the file cannot be opened the request is
+// answered with 404 Not Found or 403 Forbidden and the error returned.
+func (w *ResponseWriter) File(name string) error {
+	f, err := os.Open(name)
+	if err == nil {
+		defer f.Close()
+		var info os.FileInfo
+		if info, err = f.Stat(); err == nil && info.IsDir() {
+			err = &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		if err == nil {
+			http.ServeContent(w, w.httpRequest(), filepath.Base(name), info.ModTime(), f)
+			return nil
+		}
+	}
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+	return err
+}
+
+// Attachment sends the content as a download saved under the name, which may hold any
+// UTF-8 characters. A modtime other than the zero time is sent as Last-Modified
+// and checked against If-Modified-Since. Byte ranges are only supported when the
+// content is an io.ReadSeeker, eg. an *os.File, otherwise it is streamed as is.
+//
+//	f, _ := os.Open("reports/2016.csv")
+//	defer f.Close()
+//	r.Writer().Attachment(f, "Résumé 2016.csv", time.Time{})
+func (w *ResponseWriter) Attachment(content io.Reader, name string, modtime time.Time) error {
+	return w.disposition("attachment", content, name, modtime, -1)
+}
+
+// Inline is Attachment, for content the browser should display if it can,
+// the name is used if the user saves it
+func (w *ResponseWriter) Inline(content io.Reader, name string, modtime time.Time) error {
+	return w.disposition("inline", content, name, modtime, -1)
+}
+
+// Download sends the file stored under the key as an attachment, under the name
+// given or the last element of the key. It works with any Storage, those
+// returning seekable files, as LocalStorage and MemoryStorage do, get byte ranges.
+func (w *ResponseWriter) Download(storage Storage, key string, name ...string) error {
+	content, stored, err := storage.Get(w.httpRequest().Context(), key)
+	if errors.Is(err, ErrFileNotFound) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return err
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return err
+	}
+	defer content.Close()
+
+	filename := path.Base(key)
+	if len(name) > 0 && name[0] != "" {
+		filename = name[0]
+	}
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	return w.disposition("attachment", content, filename, stored.ModTime, stored.Size)
+}
+
+// disposition sends the content with a Content-Disposition of the kind given,
+// size is used as the Content-Length of content that cannot seek, when known
+func (w *ResponseWriter) disposition(kind string, content io.Reader, name string, modtime time.Time, size int64) error {
+	req := w.httpRequest()
+	w.Header().Set("Content-Disposition", ContentDisposition(kind, name))
+
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(w, req, name, modtime, seeker)
+		return nil
+	}
+
+	// what http.ServeContent does, without the ranges it needs to seek for
+	if !modtime.IsZero() && !modtime.Equal(time.Unix(0, 0)) {
+		if since, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil &&
+			req.Header.Get("If-None-Match") == "" && !modtime.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
+	if w.Header().Get("Content-Type") == "" {
+		contentType := mime.TypeByExtension(filepath.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+	}
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	w.Header().Set("Accept-Ranges", "none")
+	w.WriteHeader(http.StatusOK)
+
+	if req.Method == "HEAD" {
+		return nil
+	}
+	_, err := io.Copy(w, content)
+	return err
+}
+
+// httpRequest returns the request the response is for
+func (w *ResponseWriter) httpRequest() *http.Request {
+	if w.request == nil {
+		// a writer made outside of the Router, behave as for a plain GET
+		return &http.Request{Method: "GET", Header: http.Header{}}
+	}
+	return w.request
+}
+
+// ContentDisposition builds a Content-Disposition header as RFC 6266 describes, with
+// an ASCII filename for old clients and the UTF-8 one encoded as RFC 5987 says
+//
+//	ContentDisposition("attachment", "Résumé.pdf")
+//	// attachment; filename="Resume.pdf"; filename*=UTF-8''R%C3%A9sum%C3%A9.pdf
+func ContentDisposition(kind, name string) string {
+	name = SanitizeFilename(name)
+
+	var fallback, encoded strings.Builder
+	ascii := true
+	for _, r := range name {
+		switch {
+		case r == '"' || r == '\\' || r == '%':
+			fallback.WriteByte('_')
+		case r < 0x80:
+			fallback.WriteRune(r)
+		default:
+			ascii = false
+			fallback.WriteByte(asciiFallback(r))
+		}
+	}
+	if ascii && fallback.String() == name {
+		return kind + `; filename="` + name + `"`
+	}
+
+	for _, b := range []byte(name) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&encoded, "%%%02X", b)
+	}
+	return kind + `; filename="` + fallback.String() + `"; filename*=UTF-8''` + encoded.String()
+}
+
+// isAttrChar checks the byte can be left unencoded in an RFC 5987 value
+func isAttrChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
+
+// asciiFallback strips the accent off common latin letters, anything else becomes an underscore
+func asciiFallback(r rune) byte {
+	const from, to = "ÀÁÂÃÄÅàáâãäåÇçÈÉÊËèéêëÌÍÎÏìíîïÑñÒÓÔÕÖØòóôõöøÙÚÛÜùúûüÝýÿ", "AAAAAAaaaaaaCcEEEEeeeeIIIIiiiiNnOOOOOOooooooUUUUuuuuYyy"
+	i := 0
+	for _, c := range from {
+		if c == r {
+			return to[i]
+		}
+		i++
+	}
+	return '_'
+}
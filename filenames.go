@@ -0,0 +1,213 @@
+package frodo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrNoFileContent is returned by HashName for a file whose content cannot be read
+// before it is stored, eg. a Part being streamed
+var ErrNoFileContent = errors.New("frodo: the file's content is not available to name it by")
+
+// ErrFileExists is returned when moving a file onto a key that is taken
+// and the CollisionPolicy is FailOnCollision
+var ErrFileExists = errors.New("frodo: a file is already stored under that name")
+
+// NamingStrategy decides the name an uploaded file is stored under
+type NamingStrategy func(file *UploadedFile) (string, error)
+
+// CollisionPolicy decides what happens when a file is already stored under the name picked
+type CollisionPolicy int
+
+const (
+	// OverwriteOnCollision replaces the file already stored
+	OverwriteOnCollision CollisionPolicy = iota
+	// SuffixOnCollision appends a counter to the name, eg. "cat-1.png"
+	SuffixOnCollision
+	// FailOnCollision refuses to store the file, returning ErrFileExists
+	FailOnCollision
+)
+
+// maxFilenameLength is the longest name most file systems accept, in bytes
+const maxFilenameLength = 255
+
+// KeepOriginalName stores the file under the name the client gave it, sanitized
+func KeepOriginalName(file *UploadedFile) (string, error) {
+	return SanitizeFilename(file.Name()), nil
+}
+
+// RandomName stores the file under a random UUID, keeping its extension
+func RandomName(file *UploadedFile) (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	// version 4, variant 10
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	h := hex.EncodeToString(id[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:] + file.Extension(), nil
+}
+
+// HashName stores the file under the SHA-256 of its content, keeping its extension.
+// Identical uploads end up with the same name.
+func HashName(file *UploadedFile) (string, error) {
+	if file.File == nil {
+		return "", ErrNoFileContent
+	}
+	if _, err := file.File.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file.File); err != nil {
+		return "", err
+	}
+	if _, err := file.File.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)) + file.Extension(), nil
+}
+
+// SlugTimestampName stores the file under a slug of its name followed by the time
+// of the upload, eg. "My Cat.PNG" becomes "my-cat-20160102150405.png"
+func SlugTimestampName(file *UploadedFile) (string, error) {
+	name := SanitizeFilename(file.Name())
+	slug := Slugify(strings.TrimSuffix(name, path.Ext(name)))
+	if slug == "" {
+		slug = "file"
+	}
+	return slug + "-" + time.Now().UTC().Format("20060102150405") + file.Extension(), nil
+}
+
+// SanitizeFilename turns a client supplied file name into one that is safe to store:
+// directories are dropped, control and reserved characters removed, names
+// reserved on Windows escaped and the length capped, keeping the extension.
+//
+//	SanitizeFilename("../../etc/passwd")   // "passwd"
+//	SanitizeFilename("C:\\temp\\a<b>.txt") // "ab.txt"
+func SanitizeFilename(name string) string {
+	// only ever keep the last element, whichever separator the client used
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == utf8.RuneError, unicode.IsControl(r), strings.ContainsRune(`<>:"|?*`, r):
+			return -1
+		case unicode.IsSpace(r):
+			return ' '
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+	name = strings.Trim(name, ". ")
+
+	if name == "" {
+		return "file"
+	}
+
+	base := strings.TrimSuffix(name, path.Ext(name))
+	switch strings.ToUpper(base) {
+	case "CON", "PRN", "AUX", "NUL",
+		"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+		"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9":
+		name = "_" + name
+	}
+
+	if len(name) > maxFilenameLength {
+		ext := path.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		base := name[:maxFilenameLength-len(ext)]
+		// do not cut a character in half
+		for !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+		name = base + ext
+	}
+	return name
+}
+
+// Slugify lower cases the text and joins its letters and digits with dashes,
+// eg. "Hello, World!" becomes "hello-world"
+func Slugify(text string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return slug.String()
+}
+
+// maxCollisionSuffix is the highest counter SuffixOnCollision appends before giving up
+const maxCollisionSuffix = 1000
+
+// storeFile puts the body in the storage under the key, following the policy. Whether
+// the key is taken is checked by the storage as it stores the file, so concurrent
+// uploads cannot overwrite each other. When the name SuffixOnCollision picked is
+// taken in the meantime the body is rewound for the next one, a body that cannot
+// be rewound fails with ErrFileExists.
+func storeFile(ctx context.Context, storage Storage, key string, body io.Reader, contentType string, policy CollisionPolicy) (*StoredFile, error) {
+	switch policy {
+	case OverwriteOnCollision:
+		return storage.Put(ctx, key, body, contentType)
+	case FailOnCollision:
+		return storage.Put(ctx, key, body, contentType, PutOptions{NoReplace: true})
+	}
+
+	seeker, _ := body.(io.Seeker)
+	var start int64
+	if seeker != nil {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seeker = nil
+		}
+	}
+
+	ext := path.Ext(key)
+	base := strings.TrimSuffix(key, ext)
+	for i := 0; i <= maxCollisionSuffix; i++ {
+		candidate := key
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		// skip the names already taken without sending the body
+		exists, err := storage.Exists(ctx, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			continue
+		}
+
+		stored, err := storage.Put(ctx, candidate, body, contentType, PutOptions{NoReplace: true})
+		if !errors.Is(err, ErrFileExists) || seeker == nil {
+			return stored, err
+		}
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrFileExists
+}
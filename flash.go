@@ -0,0 +1,107 @@
+package frodo
+
+import (
+	"github.com/kn9ts/frodo/sessions"
+)
+
+// the session keys flash messages and old input are kept under until the next request
+const (
+	flashKey    = "_flash"
+	oldInputKey = "_old_input"
+)
+
+// OldInputExcept are the fields WithInput never keeps, so they are not
+// stored in the session nor rendered back into a form
+var OldInputExcept = []string{"password", "password_confirmation", "current_password", CSRFFieldName}
+
+func init() {
+	// how flash messages and old input are stored, cookie and file stores encode them
+	sessions.Register(map[string][]string{})
+}
+
+// loadFlashes takes the flash messages and old input kept for this request out of the
+// session, the first time they are asked for, so they do not outlive the request
+func (r *Request) loadFlashes() {
+	if r.flashed {
+		return
+	}
+	r.flashed = true
+
+	session := r.Session()
+	r.flashes, _ = session.Get(flashKey).(map[string][]string)
+	r.oldInput, _ = session.Get(oldInputKey).(map[string][]string)
+	session.Delete(flashKey)
+	session.Delete(oldInputKey)
+}
+
+// Flash keeps a message for the next request, eg. the one the client is
+// redirected to, where Flashes returns it. It is kept in the Session.
+//
+//	r.Flash("success", "Post published")
+//	http.Redirect(w, r.Request, "/posts", http.StatusSeeOther)
+func (r *Request) Flash(key, message string) {
+	r.loadFlashes()
+
+	session := r.Session()
+	kept, _ := session.Get(flashKey).(map[string][]string)
+	next := make(map[string][]string, len(kept)+1)
+	for k, messages := range kept {
+		next[k] = messages
+	}
+	next[key] = append(next[key][:len(next[key]):len(next[key])], message)
+	session.Set(flashKey, next)
+}
+
+// Flashes returns the messages the previous request kept with Flash, by key.
+// They are gone from the session once read.
+func (r *Request) Flashes() map[string][]string {
+	r.loadFlashes()
+	if r.flashes == nil {
+		return map[string][]string{}
+	}
+	return r.flashes
+}
+
+// WithInput keeps the values submitted with the request's form for the next request,
+// where Old returns them, so a form that failed validation can be filled in again.
+// The fields in OldInputExcept and those given are left out.
+//
+//	if errs := validate(r); len(errs) > 0 {
+//		for field, err := range errs {
+//			r.Flash("error."+field, err.Error())
+//		}
+//		r.WithInput()
+//		http.Redirect(w, r.Request, "/posts/create", http.StatusSeeOther)
+//		return
+//	}
+func (r *Request) WithInput(except ...string) {
+	r.loadFlashes()
+	if r.Form == nil {
+		r.ParseMultipartForm(defaultMaxMemory)
+	}
+
+	input := make(map[string][]string, len(r.Form))
+	for name, values := range r.Form {
+		if containsFold(OldInputExcept, name) || containsFold(except, name) {
+			continue
+		}
+		input[name] = values
+	}
+	r.Session().Set(oldInputKey, input)
+}
+
+// Old returns the value submitted for the field by the request before this one, kept
+// with WithInput, or the fallback given when there is none. An Edit form shows what
+// was submitted last, falling back to the stored value:
+//
+//	<input name="title" value="{{ old "title" .Post.Title }}">
+func (r *Request) Old(name string, fallback ...string) string {
+	r.loadFlashes()
+	if values := r.oldInput[name]; len(values) > 0 {
+		return values[0]
+	}
+	if len(fallback) > 0 {
+		return fallback[0]
+	}
+	return ""
+}
@@ -0,0 +1,271 @@
+package frodo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// http2Preface is what every HTTP/2 connection starts with, see RFC 9113 section 3.4
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// http2MaxFrameSize is the largest frame a peer must accept before the settings say otherwise
+const http2MaxFrameSize = 16384
+
+// Protocol describes how the request reached the server
+type Protocol struct {
+	// Version is the HTTP version, eg. "HTTP/1.1" or "HTTP/2.0"
+	Version      string
+	Major, Minor int
+
+	// TLS is true for requests made over HTTPS
+	TLS bool
+
+	// ALPN is the protocol negotiated during the TLS handshake, eg. "h2"
+	ALPN string
+}
+
+// HTTP2 checks if the request was made over HTTP/2
+func (p Protocol) HTTP2() bool {
+	return p.Major == 2
+}
+
+// H2C checks if the request was made over HTTP/2 without TLS
+func (p Protocol) H2C() bool {
+	return p.Major == 2 && !p.TLS
+}
+
+// Protocol returns the HTTP version of the request, and whether it came over TLS
+func (r *Request) Protocol() Protocol {
+	protocol := Protocol{Version: r.Proto, Major: r.ProtoMajor, Minor: r.ProtoMinor}
+	if r.TLS != nil {
+		protocol.TLS = true
+		protocol.ALPN = r.TLS.NegotiatedProtocol
+	}
+	return protocol
+}
+
+// h2cUpgrade takes the request's connection over, answering its Upgrade: h2c
+// with 101 Switching Protocols, and hands it to the server's HTTP/2 side through
+// the listener. The request itself is then answered over HTTP/2, on stream 1 as
+// RFC 7540 section 3.2 says. It reports false when the request cannot be upgraded,
+// the request is then answered over HTTP/1.1 as usual. A malformed HTTP2-Settings
+// header is answered with 400 Bad Request.
+func h2cUpgrade(w http.ResponseWriter, req *http.Request, upgrades *connListener) bool {
+	if req.ProtoMajor != 1 || !headerHasToken(req.Header, "Upgrade", "h2c") ||
+		!headerHasToken(req.Header, "Connection", "HTTP2-Settings") || len(req.Header["Http2-Settings"]) != 1 {
+		return false
+	}
+	settings, ok := http2Settings(req.Header.Get("Http2-Settings"))
+	if !ok {
+		http.Error(w, "malformed HTTP2-Settings header", http.StatusBadRequest)
+		return true
+	}
+	// the body would have to be sent over to stream 1
+	if req.ContentLength != 0 || len(req.TransferEncoding) > 0 {
+		return false
+	}
+	headers, ok := http2RequestHeaders(req)
+	if !ok {
+		return false
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return false
+	}
+	conn.SetDeadline(time.Time{})
+
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return true
+	}
+
+	if err := upgrades.push(&h2cConn{Conn: conn, r: brw.Reader, settings: settings, stream1: headers}); err != nil {
+		conn.Close()
+	}
+	return true
+}
+
+// http2Settings decodes the HTTP2-Settings header, the payload of a SETTINGS frame
+// in base64url (RFC 7540 section 3.2.1), checking the settings are within bounds
+func http2Settings(value string) ([]byte, bool) {
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(value), "="))
+	if err != nil || len(payload)%6 != 0 || len(payload) > http2MaxFrameSize {
+		return nil, false
+	}
+	for i := 0; i < len(payload); i += 6 {
+		value := binary.BigEndian.Uint32(payload[i+2:])
+		switch binary.BigEndian.Uint16(payload[i:]) {
+		case 0x2: // SETTINGS_ENABLE_PUSH
+			if value > 1 {
+				return nil, false
+			}
+		case 0x4: // SETTINGS_INITIAL_WINDOW_SIZE
+			if value > 1<<31-1 {
+				return nil, false
+			}
+		case 0x5: // SETTINGS_MAX_FRAME_SIZE
+			if value < http2MaxFrameSize || value > 1<<24-1 {
+				return nil, false
+			}
+		}
+	}
+	return payload, true
+}
+
+// http2RequestHeaders encodes the request as the HEADERS frame
+// that opens stream 1, which the HTTP/2 server then answers
+func http2RequestHeaders(req *http.Request) ([]byte, bool) {
+	var block bytes.Buffer
+	field := func(name, value string) {
+		// literal header field without indexing, a new name, no huffman coding
+		block.WriteByte(0)
+		hpackString(&block, name)
+		hpackString(&block, value)
+	}
+
+	field(":method", req.Method)
+	field(":scheme", "http")
+	field(":authority", req.Host)
+	field(":path", req.URL.RequestURI())
+	for name, values := range req.Header {
+		switch strings.ToLower(name) {
+		case "connection", "upgrade", "http2-settings", "keep-alive", "proxy-connection", "transfer-encoding", "te", "host":
+			continue
+		}
+		for _, value := range values {
+			field(strings.ToLower(name), value)
+		}
+	}
+	if block.Len() > http2MaxFrameSize {
+		return nil, false
+	}
+
+	const (
+		frameHeaders   = 0x1
+		flagEndStream  = 0x1
+		flagEndHeaders = 0x4
+	)
+	frame := make([]byte, 9, 9+block.Len())
+	frame[0], frame[1], frame[2] = byte(block.Len()>>16), byte(block.Len()>>8), byte(block.Len())
+	frame[3] = frameHeaders
+	frame[4] = flagEndStream | flagEndHeaders
+	binary.BigEndian.PutUint32(frame[5:], 1)
+	return append(frame, block.Bytes()...), true
+}
+
+// hpackString writes a string literal, its length an integer with a 7 bit prefix
+func hpackString(buf *bytes.Buffer, s string) {
+	n := len(s)
+	if n < 127 {
+		buf.WriteByte(byte(n))
+	} else {
+		buf.WriteByte(127)
+		for n -= 127; n >= 128; n >>= 7 {
+			buf.WriteByte(byte(n&0x7f | 0x80))
+		}
+		buf.WriteByte(byte(n))
+	}
+	buf.WriteString(s)
+}
+
+// h2cConn is an upgraded connection, it slips the settings of the HTTP2-Settings
+// header into the client's first SETTINGS frame, and the HEADERS frame of the
+// upgraded request in after it
+type h2cConn struct {
+	net.Conn
+	r        *bufio.Reader
+	settings []byte
+	stream1  []byte
+	pending  []byte
+	started  bool
+}
+
+func (c *h2cConn) Read(b []byte) (int, error) {
+	if !c.started {
+		c.started = true
+		// the preface, then the frame header of the client's SETTINGS
+		head := make([]byte, len(http2Preface)+9)
+		if _, err := io.ReadFull(c.r, head); err != nil {
+			return 0, err
+		}
+		if string(head[:len(http2Preface)]) != http2Preface || head[len(http2Preface)+3] != 0x4 {
+			return 0, errors.New("frodo: h2c upgrade not followed by the HTTP/2 preface")
+		}
+		frame := head[len(http2Preface):]
+		length := int(frame[0])<<16 | int(frame[1])<<8 | int(frame[2])
+		if length > http2MaxFrameSize {
+			return 0, errors.New("frodo: h2c client sent a SETTINGS frame too large")
+		}
+		settings := make([]byte, length)
+		if _, err := io.ReadFull(c.r, settings); err != nil {
+			return 0, err
+		}
+		// those of the upgrade apply first, the client's own override them
+		settings = append(c.settings[:len(c.settings):len(c.settings)], settings...)
+		if len(settings) > http2MaxFrameSize {
+			return 0, errors.New("frodo: h2c settings do not fit in a SETTINGS frame")
+		}
+		frame[0], frame[1], frame[2] = byte(len(settings)>>16), byte(len(settings)>>8), byte(len(settings))
+		c.pending = append(append(head, settings...), c.stream1...)
+	}
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.r.Read(b)
+}
+
+// connListener is a listener handing out the connections pushed to it, so
+// an http.Server can serve connections taken over from elsewhere
+type connListener struct {
+	addr   net.Addr
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{addr: addr, conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+// push hands a connection over to the server, failing once the listener is closed
+func (l *connListener) push(conn net.Conn) error {
+	select {
+	case l.conns <- conn:
+		return nil
+	case <-l.closed:
+		return net.ErrClosed
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,233 @@
+package frodo
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runH2C serves the app with h2c until the test ends, returning its address
+func runH2C(t *testing.T, app *Router) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan net.Addr, 1)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- app.Run(ctx, Server{Addr: "127.0.0.1:0", H2C: true, Ready: func(addr net.Addr) { ready <- addr }})
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	select {
+	case addr := <-ready:
+		return addr.String()
+	case err := <-stopped:
+		t.Fatal(err)
+	}
+	return ""
+}
+
+func TestH2CPriorKnowledge(t *testing.T) {
+	flushed := make(chan struct{})
+	app := New()
+	app.Get("/stream", func(w http.ResponseWriter, r *Request) {
+		io.WriteString(w, r.Protocol().Version+"\n")
+		w.(http.Flusher).Flush()
+		// the client reads the first line before the rest is written
+		select {
+		case <-flushed:
+		case <-time.After(5 * time.Second):
+		}
+		if r.Protocol().H2C() {
+			io.WriteString(w, "h2c\n")
+		}
+		r.Writer().Trailer("X-Checksum", "42")
+	})
+	addr := runH2C(t, app)
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	clients := []struct {
+		name   string
+		client *http.Client
+		body   string
+	}{
+		{"HTTP/2 prior knowledge", &http.Client{Transport: &http.Transport{Protocols: &protocols}}, "HTTP/2.0\nh2c\n"},
+		{"HTTP/1.1", &http.Client{Transport: &http.Transport{}}, "HTTP/1.1\n"},
+	}
+	for _, test := range clients {
+		t.Run(test.name, func(t *testing.T) {
+			test.client.Timeout = 10 * time.Second
+			res, err := test.client.Get("http://" + addr + "/stream")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			body := bufio.NewReader(res.Body)
+			first, err := body.ReadString('\n')
+			if err != nil || first != strings.SplitAfter(test.body, "\n")[0] {
+				t.Fatalf("the first line flushed is %q, %v", first, err)
+			}
+			flushed <- struct{}{}
+			rest, err := io.ReadAll(body)
+			if err != nil || first+string(rest) != test.body {
+				t.Fatalf("the body is %q, %v", first+string(rest), err)
+			}
+			if got := res.Trailer.Get("X-Checksum"); got != "42" {
+				t.Errorf("the trailer is %q", got)
+			}
+		})
+	}
+}
+
+// h2Frame is an HTTP/2 frame as read by the test client
+type h2Frame struct {
+	kind, flags byte
+	stream      uint32
+	payload     []byte
+}
+
+func writeH2Frame(t *testing.T, conn net.Conn, kind, flags byte, stream uint32, payload []byte) {
+	t.Helper()
+	frame := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), kind, flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[5:], stream)
+	if _, err := conn.Write(append(frame, payload...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readH2Frame reads the next frame, acknowledging the server's SETTINGS
+func readH2Frame(t *testing.T, conn net.Conn, br *bufio.Reader) h2Frame {
+	t.Helper()
+	head := make([]byte, 9)
+	if _, err := io.ReadFull(br, head); err != nil {
+		t.Fatal(err)
+	}
+	frame := h2Frame{kind: head[3], flags: head[4], stream: binary.BigEndian.Uint32(head[5:]) & 0x7FFFFFFF}
+	frame.payload = make([]byte, int(head[0])<<16|int(head[1])<<8|int(head[2]))
+	if _, err := io.ReadFull(br, frame.payload); err != nil {
+		t.Fatal(err)
+	}
+	if frame.kind == 0x4 && frame.flags&0x1 == 0 {
+		writeH2Frame(t, conn, 0x4, 0x1, 0, nil)
+	}
+	if frame.kind == 0x7 {
+		t.Fatalf("the server closed the connection with GOAWAY %x", frame.payload)
+	}
+	return frame
+}
+
+// upgradeH2C sends a request asking to switch to h2c, returning the status it was answered with
+func upgradeH2C(t *testing.T, addr, settings string) (net.Conn, *bufio.Reader, int) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	io.WriteString(conn, "GET /body HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade, HTTP2-Settings\r\n"+
+		"Upgrade: h2c\r\nHTTP2-Settings: "+settings+"\r\n\r\n")
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		res.Body.Close()
+	}
+	return conn, br, res.StatusCode
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	body := strings.Repeat("0123456789", 4)
+	app := New()
+	app.Get("/body", func(w http.ResponseWriter, r *Request) {
+		io.WriteString(w, body)
+	})
+	addr := runH2C(t, app)
+
+	// SETTINGS_INITIAL_WINDOW_SIZE of 10 bytes, the answer has to wait for a WINDOW_UPDATE
+	conn, br, status := upgradeH2C(t, addr, "AAQAAAAK")
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("the upgrade was answered with %d", status)
+	}
+	io.WriteString(conn, http2Preface)
+	writeH2Frame(t, conn, 0x4, 0, 0, nil)
+
+	var received strings.Builder
+	sawHeaders, updated := false, false
+	for {
+		frame := readH2Frame(t, conn, br)
+		if frame.stream != 1 {
+			continue
+		}
+		switch frame.kind {
+		case 0x1: // HEADERS
+			sawHeaders = true
+		case 0x0: // DATA
+			if !updated {
+				if len(frame.payload) != 10 {
+					t.Fatalf("sent %d bytes within a window of 10, the HTTP2-Settings were not applied", len(frame.payload))
+				}
+				updated = true
+				increment := binary.BigEndian.AppendUint32(nil, 1000)
+				writeH2Frame(t, conn, 0x8, 0, 1, increment)
+			}
+			received.Write(frame.payload)
+		}
+		if frame.flags&0x1 != 0 { // END_STREAM
+			break
+		}
+	}
+	if !sawHeaders || received.String() != body {
+		t.Errorf("stream 1 answered with headers %v and %q", sawHeaders, received.String())
+	}
+}
+
+func TestH2CUpgradeMalformedSettings(t *testing.T) {
+	app := New()
+	app.Get("/body", func(w http.ResponseWriter, r *Request) {})
+	addr := runH2C(t, app)
+
+	for _, settings := range []string{"AAQAAAAK!", "AAQAAAAKAA", "AAIAAAAC"} {
+		if _, _, status := upgradeH2C(t, addr, settings); status != http.StatusBadRequest {
+			t.Errorf("HTTP2-Settings %q was answered with %d", settings, status)
+		}
+	}
+}
+
+func TestHTTP2Settings(t *testing.T) {
+	tests := []struct {
+		value string
+		ok    bool
+	}{
+		{"", true},
+		{"AAQAAAAK", true},
+		{"AAMAAABkAAQAAP__", true},
+		{"AAUAAEAAAAIAAAABAJkAAAAH", true}, // unknown settings are ignored
+		{" AAQAAAAK ", true},
+		{"AAQAAAAK!", false},
+		{"AAQAAAAK+", false},                      // not base64url
+		{"AAQAAAAKAA", false},                     // not a multiple of 6 bytes
+		{"AAIAAAAC", false},                       // SETTINGS_ENABLE_PUSH of 2
+		{"AAT_____", false},                       // SETTINGS_INITIAL_WINDOW_SIZE over 2^31-1
+		{"AAUAAAAB", false},                       // SETTINGS_MAX_FRAME_SIZE under 16384
+		{"AAUBAAAA", false},                       // SETTINGS_MAX_FRAME_SIZE over 2^24-1
+		{strings.Repeat("AAMAAABk", 2731), false}, // larger than a frame
+	}
+	for _, test := range tests {
+		if _, ok := http2Settings(test.value); ok != test.ok {
+			t.Errorf("http2Settings(%.20q) is %v, want %v", test.value, ok, test.ok)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Package images decodes uploaded JPEG, PNG and GIF images, checks their
+// dimensions, and stores resized variants of them in a frodo.Storage.
+//
+//	pipeline := &images.Pipeline{
+//		Storage: storage,
+//		Bounds:  images.Bounds{MinWidth: 200, MinHeight: 200},
+//		Variants: []images.Variant{
+//			{Name: "thumb", Width: 150, Height: 150, Mode: images.Fill},
+//			{Name: "large", Width: 1200, Height: 1200},
+//		},
+//	}
+//	stored, err := pipeline.Process(r.Context(), file, "avatars/42.jpg")
+//
+// Resampling is done in pure Go, and EXIF orientation is applied so pictures
+// taken with a phone turned sideways come out the right way up. Re-encoding
+// strips the EXIF data, including any GPS coordinates.
+package images
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // register the GIF decoder
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+
+	"github.com/kn9ts/frodo"
+)
+
+// ErrUnsupportedFormat is returned for images that are not JPEG, PNG or GIF
+var ErrUnsupportedFormat = errors.New("images: unsupported image format")
+
+// ErrTooManyPixels is returned when decoding an image larger than Bounds.MaxPixels allows
+var ErrTooManyPixels = errors.New("images: image has too many pixels")
+
+// Image is a decoded image, turned the right way up
+type Image struct {
+	*image.RGBA
+
+	// Format is the format the image was decoded from: "jpeg", "png" or "gif"
+	Format string
+
+	// Orientation is the EXIF orientation the image was stored with, 1 to 8
+	Orientation int
+}
+
+// Width returns the width of the image in pixels
+func (img *Image) Width() int {
+	return img.Bounds().Dx()
+}
+
+// Height returns the height of the image in pixels
+func (img *Image) Height() int {
+	return img.Bounds().Dy()
+}
+
+// Config describes an image without decoding its pixels
+type Config struct {
+	Format        string
+	Width, Height int
+	Orientation   int
+}
+
+// DecodeConfig reads the format and dimensions of an image, as it will be displayed,
+// ie. the width and height are swapped for images the EXIF orientation turns sideways
+func DecodeConfig(src io.Reader) (Config, error) {
+	data, err := readAll(src)
+	if err != nil {
+		return Config{}, err
+	}
+	return decodeConfig(data)
+}
+
+// Decode decodes a JPEG, PNG or GIF image and applies its EXIF orientation.
+// For GIFs only the first frame is decoded.
+func Decode(src io.Reader) (*Image, error) {
+	data, err := readAll(src)
+	if err != nil {
+		return nil, err
+	}
+	return decode(data, 0)
+}
+
+// Dimensions returns the width and height of the uploaded image, as it will be displayed
+func Dimensions(file *frodo.UploadedFile) (width, height int, err error) {
+	config, err := DecodeConfig(file)
+	return config.Width, config.Height, err
+}
+
+// readAll reads the whole image, from the start when the source can seek
+func readAll(src io.Reader) ([]byte, error) {
+	if seeker, ok := src.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(src)
+}
+
+func decodeConfig(data []byte) (Config, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return Config{}, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return Config{}, err
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = exifOrientation(data)
+	}
+	width, height := config.Width, config.Height
+	if orientation >= 5 {
+		width, height = height, width
+	}
+	return Config{Format: format, Width: width, Height: height, Orientation: orientation}, nil
+}
+
+// decode decodes the image, refusing ones with more than maxPixels when it is set
+func decode(data []byte, maxPixels int64) (*Image, error) {
+	config, err := decodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if maxPixels > 0 && int64(config.Width)*int64(config.Height) > maxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrTooManyPixels, config.Width, config.Height)
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return &Image{
+		RGBA:        orient(toRGBA(decoded), config.Orientation),
+		Format:      config.Format,
+		Orientation: config.Orientation,
+	}, nil
+}
+
+// toRGBA converts the image to premultiplied RGBA, with its origin at 0,0
+func toRGBA(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	if rgba, ok := src.(*image.RGBA); ok && bounds.Min == (image.Point{}) {
+		return rgba
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+	return dst
+}
+
+// Bounds limits the dimensions of an image, zero means no limit
+type Bounds struct {
+	MinWidth, MinHeight int
+	MaxWidth, MaxHeight int
+
+	// MaxPixels guards against decompression bombs, images with more pixels
+	// are refused before they are decoded
+	MaxPixels int64
+}
+
+// Check validates the dimensions of the image, returning what is wrong with them
+func (b Bounds) Check(config Config) []string {
+	var problems []string
+	size := fmt.Sprintf("%dx%d pixels", config.Width, config.Height)
+	if b.MinWidth > 0 && config.Width < b.MinWidth {
+		problems = append(problems, fmt.Sprintf("is %s, narrower than the %d required", size, b.MinWidth))
+	}
+	if b.MinHeight > 0 && config.Height < b.MinHeight {
+		problems = append(problems, fmt.Sprintf("is %s, shorter than the %d required", size, b.MinHeight))
+	}
+	if b.MaxWidth > 0 && config.Width > b.MaxWidth {
+		problems = append(problems, fmt.Sprintf("is %s, wider than the %d allowed", size, b.MaxWidth))
+	}
+	if b.MaxHeight > 0 && config.Height > b.MaxHeight {
+		problems = append(problems, fmt.Sprintf("is %s, taller than the %d allowed", size, b.MaxHeight))
+	}
+	if b.MaxPixels > 0 && int64(config.Width)*int64(config.Height) > b.MaxPixels {
+		problems = append(problems, fmt.Sprintf("is %s, more than the %d allowed", size, b.MaxPixels))
+	}
+	return problems
+}
+
+// CheckFile validates the uploaded file is an image within the bounds,
+// returning what is wrong with it
+func (b Bounds) CheckFile(file *frodo.UploadedFile) []string {
+	name := frodo.SanitizeFilename(file.Name())
+	config, err := DecodeConfig(file)
+	if err != nil {
+		return []string{name + " is not a JPEG, PNG or GIF image"}
+	}
+
+	problems := b.Check(config)
+	for i, problem := range problems {
+		problems[i] = name + " " + problem
+	}
+	return problems
+}
+
+// Validate is middleware checking the images uploaded under the field are within the bounds.
+// Like frodo.ValidateUploads, when one is not the request is answered with
+// 422 Unprocessable Entity and a JSON body listing the problems.
+//
+//	r.Post("/avatars", images.Validate("avatar", images.Bounds{MinWidth: 200, MinHeight: 200}), storeAvatar)
+func Validate(field string, bounds Bounds) frodo.Handler {
+	return func(w http.ResponseWriter, r *frodo.Request) {
+		errs := frodo.UploadErrors{}
+		for _, file := range r.UploadedFiles(field) {
+			errs[field] = append(errs[field], bounds.CheckFile(file)...)
+		}
+
+		if len(errs[field]) > 0 {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]frodo.UploadErrors{"errors": errs})
+			return
+		}
+		r.Next()
+	}
+}
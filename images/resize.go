@@ -0,0 +1,169 @@
+package images
+
+import (
+	"image"
+	"math"
+)
+
+// Mode decides how an image is fitted into a variant's width and height
+type Mode int
+
+const (
+	// Fit scales the image down to fit inside the width and height, keeping its
+	// aspect ratio. Images that already fit are not scaled up.
+	Fit Mode = iota
+	// Fill scales the image to cover the width and height, keeping its aspect
+	// ratio, and crops what sticks out, keeping the center
+	Fill
+)
+
+// Resize returns the image fitted into width and height following the mode.
+// A width or height of zero leaves that side free with Fit, and takes the
+// other side's value with Fill.
+func Resize(src *image.RGBA, width, height int, mode Mode) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if w == 0 || h == 0 {
+		return src
+	}
+
+	if mode == Fill {
+		if width <= 0 {
+			width = height
+		}
+		if height <= 0 {
+			height = width
+		}
+		if width <= 0 {
+			return src
+		}
+
+		// crop the largest centered area with the aspect ratio wanted
+		crop := image.Rect(0, 0, w, h)
+		if w*height > h*width {
+			cw := int(math.Round(float64(h) * float64(width) / float64(height)))
+			crop.Min.X = (w - cw) / 2
+			crop.Max.X = crop.Min.X + cw
+		} else {
+			ch := int(math.Round(float64(w) * float64(height) / float64(width)))
+			crop.Min.Y = (h - ch) / 2
+			crop.Max.Y = crop.Min.Y + ch
+		}
+		return resample(src.SubImage(crop).(*image.RGBA), width, height)
+	}
+
+	scale := 1.0
+	if width > 0 && w > width {
+		scale = float64(width) / float64(w)
+	}
+	if height > 0 && float64(h)*scale > float64(height) {
+		scale = float64(height) / float64(h)
+	}
+	if scale == 1 {
+		return src
+	}
+	return resample(src, max(1, int(math.Round(float64(w)*scale))), max(1, int(math.Round(float64(h)*scale))))
+}
+
+// catmullRom is the cubic the resampling filter uses, sharp without ringing much
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (3*x*x*x - 5*x*x + 2) / 2
+	case x < 2:
+		return (-x*x*x + 5*x*x - 8*x + 4) / 2
+	}
+	return 0
+}
+
+// weights are the source pixels, and how much of each, that make up a destination pixel
+type weights struct {
+	start   int
+	factors []float64
+}
+
+// computeWeights works out the filter weights for scaling size source pixels to scaled ones.
+// When shrinking the filter is stretched, so every source pixel counts.
+func computeWeights(size, scaled int) []weights {
+	ratio := float64(size) / float64(scaled)
+	stretch := math.Max(ratio, 1)
+	radius := 2 * stretch
+
+	all := make([]weights, scaled)
+	for i := range all {
+		center := (float64(i)+0.5)*ratio - 0.5
+		start := max(0, int(math.Ceil(center-radius)))
+		end := min(size-1, int(math.Floor(center+radius)))
+
+		factors := make([]float64, 0, end-start+1)
+		var sum float64
+		for j := start; j <= end; j++ {
+			factor := catmullRom((float64(j) - center) / stretch)
+			factors = append(factors, factor)
+			sum += factor
+		}
+		if sum != 0 {
+			for j := range factors {
+				factors[j] /= sum
+			}
+		}
+		all[i] = weights{start: start, factors: factors}
+	}
+	return all
+}
+
+// resample scales the image to width by height, filtering horizontally then vertically.
+// The pixels are premultiplied, so transparent ones do not bleed their color.
+func resample(src *image.RGBA, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	columns := computeWeights(w, width)
+	tmp := make([]float64, width*h*4)
+	for y := 0; y < h; y++ {
+		row := src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+		for x, col := range columns {
+			var r, g, b, a float64
+			for j, factor := range col.factors {
+				p := row[(col.start+j)*4:]
+				r += float64(p[0]) * factor
+				g += float64(p[1]) * factor
+				b += float64(p[2]) * factor
+				a += float64(p[3]) * factor
+			}
+			t := tmp[(y*width+x)*4:]
+			t[0], t[1], t[2], t[3] = r, g, b, a
+		}
+	}
+
+	rows := computeWeights(h, height)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, row := range rows {
+		for x := 0; x < width; x++ {
+			var r, g, b, a float64
+			for j, factor := range row.factors {
+				t := tmp[((row.start+j)*width+x)*4:]
+				r += t[0] * factor
+				g += t[1] * factor
+				b += t[2] * factor
+				a += t[3] * factor
+			}
+			alpha := clamp(a)
+			d := dst.Pix[dst.PixOffset(x, y):]
+			// premultiplied colors cannot be brighter than their alpha
+			d[0], d[1], d[2], d[3] = min(clamp(r), alpha), min(clamp(g), alpha), min(clamp(b), alpha), alpha
+		}
+	}
+	return dst
+}
+
+// clamp rounds the value into a byte
+func clamp(v float64) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
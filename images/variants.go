@@ -0,0 +1,145 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/kn9ts/frodo"
+)
+
+// DefaultQuality is the JPEG quality variants are encoded with unless they say otherwise
+var DefaultQuality = 85
+
+// Variant describes a resized copy of an image to store
+type Variant struct {
+	// Name tells the variants apart, it is added to the key they are stored under,
+	// eg. "avatars/42.jpg" is stored as "avatars/42-thumb.jpg"
+	Name string
+
+	// Width and Height are the box the image is fitted into, following the Mode.
+	// With both zero the image is stored at its own size, turned the right
+	// way up and without its EXIF data.
+	Width, Height int
+	Mode          Mode
+
+	// Format is "jpeg", or "jpg", or "png", by default JPEGs stay JPEGs and everything
+	// else is stored as PNG
+	Format string
+
+	// Quality is the JPEG quality from 1 to 100, DefaultQuality if zero
+	Quality int
+}
+
+// Pipeline decodes uploaded images and stores the variants of them in a Storage
+type Pipeline struct {
+	Storage  frodo.Storage
+	Bounds   Bounds
+	Variants []Variant
+}
+
+// Process decodes the image, checks it is within the pipeline's Bounds and stores
+// every variant of it, returning them by name. If storing any of them fails,
+// those already stored are deleted.
+func (p *Pipeline) Process(ctx context.Context, src io.Reader, key string) (map[string]*frodo.StoredFile, error) {
+	if p.Storage == nil {
+		return nil, errors.New("images: the Pipeline has no Storage")
+	}
+
+	data, err := readAll(src)
+	if err != nil {
+		return nil, err
+	}
+	config, err := decodeConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	if problems := p.Bounds.Check(config); len(problems) > 0 {
+		return nil, fmt.Errorf("images: image %s", strings.Join(problems, ", "))
+	}
+	img, err := decode(data, p.Bounds.MaxPixels)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make(map[string]*frodo.StoredFile, len(p.Variants))
+	for _, variant := range p.Variants {
+		file, err := p.store(ctx, img, variant, key)
+		if err != nil {
+			for _, done := range stored {
+				p.Storage.Delete(context.WithoutCancel(ctx), done.Key)
+			}
+			return nil, fmt.Errorf("images: storing the %q variant: %w", variant.Name, err)
+		}
+		stored[variant.Name] = file
+	}
+	return stored, nil
+}
+
+// store resizes, encodes and stores a single variant
+func (p *Pipeline) store(ctx context.Context, img *Image, variant Variant, key string) (*frodo.StoredFile, error) {
+	// the format decides the encoder, the content type and the extension alike
+	var format string
+	switch variant.Format {
+	case "":
+		format = "png"
+		if img.Format == "jpeg" {
+			format = "jpeg"
+		}
+	case "jpeg", "jpg":
+		format = "jpeg"
+	case "png":
+		format = "png"
+	default:
+		return nil, fmt.Errorf("%w: cannot encode %q", ErrUnsupportedFormat, variant.Format)
+	}
+
+	resized := img.RGBA
+	if variant.Width > 0 || variant.Height > 0 {
+		resized = Resize(img.RGBA, variant.Width, variant.Height, variant.Mode)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, resized, format, variant.Quality); err != nil {
+		return nil, err
+	}
+	contentType, ext := "image/png", ".png"
+	if format == "jpeg" {
+		contentType, ext = "image/jpeg", ".jpg"
+	}
+	return p.Storage.Put(ctx, VariantKey(key, variant.Name, ext), &buf, contentType)
+}
+
+// VariantKey returns the key a variant is stored under: the name is added
+// after the base of the key and the extension replaced
+//
+//	VariantKey("avatars/42.png", "thumb", ".jpg") // "avatars/42-thumb.jpg"
+func VariantKey(key, name, ext string) string {
+	base := strings.TrimSuffix(key, path.Ext(key))
+	if name != "" {
+		base += "-" + name
+	}
+	return base + ext
+}
+
+// Encode writes the image as a "jpeg" or "png". Quality only matters for JPEGs,
+// DefaultQuality is used if it is zero.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case "jpeg", "jpg":
+		if quality <= 0 {
+			quality = DefaultQuality
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: min(quality, 100)})
+	case "png":
+		return png.Encode(w, img)
+	}
+	return fmt.Errorf("%w: cannot encode %q", ErrUnsupportedFormat, format)
+}
@@ -0,0 +1,52 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/kn9ts/frodo"
+)
+
+func TestPipelineVariantFormats(t *testing.T) {
+	var src bytes.Buffer
+	if err := png.Encode(&src, image.NewRGBA(image.Rect(0, 0, 40, 20))); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		format      string
+		key         string
+		contentType string
+	}{
+		{"", "a/b-v.png", "image/png"},
+		{"png", "a/b-v.png", "image/png"},
+		{"jpeg", "a/b-v.jpg", "image/jpeg"},
+		{"jpg", "a/b-v.jpg", "image/jpeg"},
+	}
+	for _, test := range tests {
+		p := &Pipeline{
+			Storage:  frodo.NewMemoryStorage(),
+			Variants: []Variant{{Name: "v", Width: 10, Format: test.format}},
+		}
+		stored, err := p.Process(context.Background(), bytes.NewReader(src.Bytes()), "a/b.png")
+		if err != nil {
+			t.Fatalf("format %q: %v", test.format, err)
+		}
+		if stored["v"].Key != test.key || stored["v"].ContentType != test.contentType {
+			t.Errorf("format %q stored %q as %q, want %q as %q",
+				test.format, stored["v"].Key, stored["v"].ContentType, test.key, test.contentType)
+		}
+	}
+
+	p := &Pipeline{
+		Storage:  frodo.NewMemoryStorage(),
+		Variants: []Variant{{Name: "v", Format: "gif"}},
+	}
+	if _, err := p.Process(context.Background(), bytes.NewReader(src.Bytes()), "a/b.png"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("format \"gif\" returned %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package frodo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation
+const listenFDsStart = 3
+
+// activation holds the listeners passed by systemd, they can only be taken once
+var activation struct {
+	once      sync.Once
+	listeners []net.Listener
+	names     []string
+	err       error
+}
+
+// ActivationListeners returns the listeners systemd passed to the process through
+// socket activation, see systemd.socket(5). Nil is returned when there are none.
+// The environment variables are cleared so child processes do not take them too.
+func ActivationListeners() ([]net.Listener, error) {
+	listeners, _, err := activationListeners()
+	return listeners, err
+}
+
+// activationListeners returns the systemd listeners along with their FileDescriptorName
+func activationListeners() ([]net.Listener, []string, error) {
+	activation.once.Do(func() {
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			return
+		}
+		count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err != nil || count <= 0 {
+			return
+		}
+		names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+
+		for i := 0; i < count; i++ {
+			fd := listenFDsStart + i
+			syscall.CloseOnExec(fd)
+
+			name := "LISTEN_FD_" + strconv.Itoa(fd)
+			if i < len(names) && names[i] != "" {
+				name = names[i]
+			}
+			file := os.NewFile(uintptr(fd), name)
+			ln, err := net.FileListener(file)
+			file.Close()
+			if err != nil {
+				activation.err = fmt.Errorf("frodo: file descriptor %d passed by systemd: %w", fd, err)
+				return
+			}
+			activation.listeners = append(activation.listeners, ln)
+			activation.names = append(activation.names, name)
+		}
+	})
+	return activation.listeners, activation.names, activation.err
+}
+
+// Listen opens the listeners for an address, which is one of
+//
+//	":3102", "127.0.0.1:8080", "[::1]:8080" TCP, on all or a specific interface
+//	"tcp:127.0.0.1:8080"                     the same, explicitly
+//	"unix:/run/app.sock"                     a Unix domain socket
+//	"systemd"                                every socket passed by systemd
+//	"systemd:web"                            those passed with FileDescriptorName=web
+//
+// A Unix socket left behind by a process that is gone is removed first, one that is
+// still being listened on is an error. The socket is removed again when its listener
+// is closed, socketMode sets its permissions unless it is zero.
+func Listen(addr string, socketMode os.FileMode) ([]net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		ln, err := listenUnix(strings.TrimPrefix(addr, "unix:"), socketMode)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+
+	case addr == "systemd" || strings.HasPrefix(addr, "systemd:"):
+		all, names, err := activationListeners()
+		if err != nil {
+			return nil, err
+		}
+		wanted := strings.TrimPrefix(strings.TrimPrefix(addr, "systemd"), ":")
+		var listeners []net.Listener
+		for i, ln := range all {
+			if wanted == "" || names[i] == wanted {
+				listeners = append(listeners, ln)
+			}
+		}
+		if len(listeners) == 0 {
+			return nil, fmt.Errorf("frodo: no socket passed by systemd for %q", addr)
+		}
+		return listeners, nil
+	}
+
+	ln, err := net.Listen("tcp", strings.TrimPrefix(addr, "tcp:"))
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{ln}, nil
+}
+
+// listenUnix listens on a Unix domain socket, cleaning up a stale one first
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("frodo: %s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("frodo: %s is already in use", path)
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// ServeListener serves the application on a listener opened elsewhere, until the
+// process receives SIGINT or SIGTERM. The listener is closed on shutdown.
+func (r *Router) ServeListener(ln net.Listener, config ...Server) error {
+	var cfg Server
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	cfg.Listeners = append(cfg.Listeners, ln)
+	return r.Run(context.Background(), cfg)
+}
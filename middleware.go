@@ -47,7 +47,7 @@ func (m *RequestMiddleware) Next(args ...interface{}) {
 func (m *RequestMiddleware) typeCastAndCall(run Middleware) {
 	// 1st check if the route handler is HandleFunc
 	if handle, hasTypeCasted := run.(Handler); hasTypeCasted {
-		handle(m.ResponseWriter, m.Request)
+		handle(m.ResponseWriter.handlerWriter(), m.Request)
 	} else {
 		// if not, then is it an implementation of ControllerInterface
 		if ctrl, ok := run.(CRUDController); ok {
@@ -82,7 +82,7 @@ func (m *RequestMiddleware) typeCastAndCall(run Middleware) {
 			// }
 
 			// If no Controller.Attribute.Method was provided, run Index as the default fallback
-			ctrl.Index(m.ResponseWriter, m.Request)
+			ctrl.Index(m.ResponseWriter.handlerWriter(), m.Request)
 		} else {
 			// No Handler or Controller was found, run internal server error: 500
 			m.ResponseWriter.WriteHeader(404)
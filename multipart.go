@@ -0,0 +1,221 @@
+package frodo
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+// ErrPartTooLarge is returned when reading more of a part than MultipartOptions.MaxPartSize allows
+var ErrPartTooLarge = errors.New("frodo: multipart part too large")
+
+// ErrRequestTooLarge is returned when the body is larger than MultipartOptions.MaxRequestSize allows
+var ErrRequestTooLarge = errors.New("frodo: request body too large")
+
+// ErrTooManyParts is returned when the form has more parts than MultipartOptions.MaxParts allows
+var ErrTooManyParts = errors.New("frodo: too many multipart parts")
+
+// MultipartOptions limits and instruments the streaming of a multipart form
+type MultipartOptions struct {
+	// MaxPartSize caps the bytes read from any one part, zero means no limit
+	MaxPartSize int64
+
+	// MaxRequestSize caps the bytes read from the whole body, zero means no limit
+	MaxRequestSize int64
+
+	// MaxParts caps the number of parts, zero means no limit
+	MaxParts int
+
+	// Progress is called as a part is read, with the bytes read from it so far
+	Progress func(part *Part, read int64)
+
+	// Hash is used to checksum every part as it is read, sha256 by default
+	Hash func() hash.Hash
+}
+
+// Part is a single field or file of a multipart form, read straight off the request body.
+// Reading a Part enforces the limits set, reports progress and hashes the content.
+type Part struct {
+	*multipart.Part
+
+	body     *bufio.Reader
+	request  *Request
+	opts     *MultipartOptions
+	hash     hash.Hash
+	read     int64
+	tooLarge bool
+}
+
+// Multipart streams the request's multipart form, calling fn with each part as it arrives
+// so large files can be piped straight into a Storage without being buffered.
+// A part that is not read is skipped, returning an error from fn stops the stream.
+//
+//	err := r.Multipart(func(part *frodo.Part) error {
+//		if !part.IsFile() {
+//			return nil
+//		}
+//		_, err := part.Store(storage, "")
+//		return err
+//	}, frodo.MultipartOptions{MaxPartSize: 1 << 30})
+func (r *Request) Multipart(fn func(*Part) error, opts ...MultipartOptions) error {
+	var opt MultipartOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.Hash == nil {
+		opt.Hash = sha256.New
+	}
+
+	if opt.MaxRequestSize > 0 {
+		var w http.ResponseWriter
+		if r.writer != nil {
+			w = r.writer
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, opt.MaxRequestSize)
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	for count := 1; ; count++ {
+		raw, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return limitError(err)
+		}
+		if opt.MaxParts > 0 && count > opt.MaxParts {
+			raw.Close()
+			return ErrTooManyParts
+		}
+
+		part := &Part{Part: raw, request: r, opts: &opt, hash: opt.Hash()}
+		part.body = bufio.NewReaderSize(partReader{part}, 512)
+		err = fn(part)
+		raw.Close()
+		if err != nil {
+			return limitError(err)
+		}
+	}
+}
+
+// IsFile checks if the part is a file rather than a plain form field
+func (p *Part) IsFile() bool {
+	return p.FileName() != ""
+}
+
+// SafeFileName returns the name the client gave the file, sanitized
+func (p *Part) SafeFileName() string {
+	return SanitizeFilename(p.FileName())
+}
+
+// Read reads the part's content
+func (p *Part) Read(b []byte) (int, error) {
+	return p.body.Read(b)
+}
+
+// Value reads a plain form field, at most 1MB of it
+func (p *Part) Value() (string, error) {
+	value, err := io.ReadAll(io.LimitReader(p, 1<<20))
+	return string(value), err
+}
+
+// Size returns how many bytes have been read from the part so far
+func (p *Part) Size() int64 {
+	return p.read
+}
+
+// Sum returns the hex encoded checksum of the bytes read from the part so far,
+// once the part has been read to the end it is the checksum of the whole part
+func (p *Part) Sum() string {
+	return hex.EncodeToString(p.hash.Sum(nil))
+}
+
+// MimeType sniffs the mime/type from the first bytes of the part without consuming them
+func (p *Part) MimeType() string {
+	head, _ := p.body.Peek(512)
+	return DetectContentType(head)
+}
+
+// Store pipes the rest of the part into the storage under the given key. When key
+// is empty the Router's UploadNaming picks one, the sanitized file name by default,
+// and its UploadCollision decides what happens when the key is taken. A part over
+// the size limit fails without anything stored, the storage throws away what it got.
+func (p *Part) Store(storage Storage, key string) (*StoredFile, error) {
+	naming, collision := NamingStrategy(KeepOriginalName), OverwriteOnCollision
+	if router := p.request.router; router != nil {
+		if router.UploadNaming != nil {
+			naming = router.UploadNaming
+		}
+		collision = router.UploadCollision
+	}
+	if key == "" {
+		// the part is not read yet, strategies needing the content cannot name it
+		file := &UploadedFile{
+			FileHeader: &multipart.FileHeader{Filename: p.FileName(), Header: p.Header},
+			ctx:        p.request.Context(),
+		}
+		var err error
+		if key, err = naming(file); err != nil {
+			return nil, err
+		}
+	}
+
+	stored, err := storeFile(p.request.Context(), storage, key, p, p.MimeType(), collision)
+	if err != nil {
+		return nil, limitError(err)
+	}
+	return stored, nil
+}
+
+// partReader reads the raw part, enforcing the size limit,
+// hashing and reporting the progress
+type partReader struct {
+	p *Part
+}
+
+func (r partReader) Read(b []byte) (int, error) {
+	p := r.p
+	if limit := p.opts.MaxPartSize; limit > 0 {
+		if p.read >= limit {
+			// anything left over the limit?
+			var probe [1]byte
+			if n, _ := p.Part.Read(probe[:]); n > 0 {
+				p.tooLarge = true
+				return 0, fmt.Errorf("%w: %q is over %d bytes", ErrPartTooLarge, p.FormName(), limit)
+			}
+			return 0, io.EOF
+		}
+		if remaining := limit - p.read; int64(len(b)) > remaining {
+			b = b[:remaining]
+		}
+	}
+
+	n, err := p.Part.Read(b)
+	if n > 0 {
+		p.hash.Write(b[:n])
+		p.read += int64(n)
+		if p.opts.Progress != nil {
+			p.opts.Progress(p, p.read)
+		}
+	}
+	return n, err
+}
+
+// limitError turns the error http.MaxBytesReader returns into ErrRequestTooLarge
+func limitError(err error) error {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return fmt.Errorf("%w: over %d bytes", ErrRequestTooLarge, maxBytes.Limit)
+	}
+	return err
+}
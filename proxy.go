@@ -0,0 +1,220 @@
+package frodo
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// forwarded is what the proxies in front of the application say about the client
+type forwarded struct {
+	ip, scheme, host string
+}
+
+// trustedProxies parses the Router's TrustedProxies, once
+func (r *Router) trustedProxies() []func(netip.Addr) bool {
+	r.proxiesOnce.Do(func() {
+		for _, proxy := range r.TrustedProxies {
+			switch proxy = strings.TrimSpace(proxy); strings.ToLower(proxy) {
+			case "loopback":
+				r.proxies = append(r.proxies, netip.Addr.IsLoopback)
+				continue
+			case "private":
+				r.proxies = append(r.proxies, netip.Addr.IsPrivate)
+				continue
+			}
+
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(proxy)
+				if addrErr != nil {
+					logf(r.Logger, "[ERROR] Ignoring the trusted proxy %q, it is neither an IP nor a CIDR", proxy)
+					continue
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+				// addresses are unmapped before they are checked
+				prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+			}
+			r.proxies = append(r.proxies, prefix.Masked().Contains)
+		}
+	})
+	return r.proxies
+}
+
+// trusts checks if the address is one of the TrustedProxies
+func (r *Router) trusts(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, trusted := range r.trustedProxies() {
+		if trusted(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwarded works out who the client is, where it connected to and how. The connection's
+// peer is taken at its word only when it is one of the TrustedProxies, the hops it forwards
+// for are then walked from the nearest, right to left, up to the first that is not trusted.
+// The Forwarded header (RFC 7239) is preferred over X-Forwarded-For and X-Real-Ip.
+func (r *Request) forwarded() forwarded {
+	if r.proxied != nil {
+		return *r.proxied
+	}
+
+	peer := remoteIP(r.RemoteAddr)
+	fwd := forwarded{ip: peer, host: r.Request.Host, scheme: "http"}
+	if r.TLS != nil {
+		fwd.scheme = "https"
+	}
+	// worked out once for the request
+	defer func() { r.proxied = &fwd }()
+
+	if r.router == nil || len(r.router.TrustedProxies) == 0 {
+		return fwd
+	}
+	addr, err := netip.ParseAddr(peer)
+	if err != nil || !r.router.trusts(addr) {
+		return fwd
+	}
+
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		elements := splitQuoted(strings.Join(values, ","), ',')
+		for i := len(elements) - 1; i >= 0; i-- {
+			params := forwardedParams(elements[i])
+			if proto := strings.ToLower(params["proto"]); proto == "http" || proto == "https" {
+				fwd.scheme = proto
+			}
+			if host := params["host"]; host != "" {
+				fwd.host = host
+			}
+			ip := forwardedNode(params["for"])
+			if !ip.IsValid() {
+				// obfuscated or unknown, the nearest address known has to do
+				break
+			}
+			fwd.ip = ip.Unmap().String()
+			if !r.router.trusts(ip) {
+				break
+			}
+		}
+		return fwd
+	}
+
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		hops := strings.Split(strings.Join(values, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			fwd.ip = ip.Unmap().String()
+			if !r.router.trusts(ip) {
+				break
+			}
+		}
+	} else if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-Ip"))); err == nil {
+		fwd.ip = ip.Unmap().String()
+	}
+	if proto := strings.ToLower(lastValue(r.Header.Get("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+		fwd.scheme = proto
+	}
+	if host := lastValue(r.Header.Get("X-Forwarded-Host")); host != "" {
+		fwd.host = host
+	}
+	return fwd
+}
+
+// remoteIP strips the port off the address of the connection's peer, IPv6 addresses
+// included, eg. "[::1]:8080" is "::1"
+func remoteIP(remoteAddr string) string {
+	remoteAddr = strings.TrimSpace(remoteAddr)
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+	remoteAddr = strings.TrimSuffix(strings.TrimPrefix(remoteAddr, "["), "]")
+	if addr, err := netip.ParseAddr(remoteAddr); err == nil {
+		return addr.Unmap().String()
+	}
+	return remoteAddr
+}
+
+// lastValue returns the last of the comma separated values, the one the nearest proxy added
+func lastValue(header string) string {
+	if i := strings.LastIndexByte(header, ','); i >= 0 {
+		header = header[i+1:]
+	}
+	return strings.TrimSpace(header)
+}
+
+// forwardedParams parses the pairs of a Forwarded element, eg. `for=192.0.2.60;proto=https`
+func forwardedParams(element string) map[string]string {
+	params := make(map[string]string)
+	for _, pair := range splitQuoted(element, ';') {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = strings.ReplaceAll(value[1:len(value)-1], `\`, "")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return params
+}
+
+// forwardedNode parses the address of a node, eg. `192.0.2.43:47011` or `[2001:db8::17]:4711`.
+// Obfuscated nodes and "unknown" are not valid addresses.
+func forwardedNode(node string) netip.Addr {
+	if strings.HasPrefix(node, "[") {
+		if end := strings.IndexByte(node, ']'); end > 0 {
+			node = node[1:end]
+		}
+	} else if strings.Count(node, ":") == 1 {
+		node, _, _ = strings.Cut(node, ":")
+	}
+	addr, err := netip.ParseAddr(node)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr
+}
+
+// splitQuoted splits s on sep, leaving the separators within quoted strings alone
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// Scheme returns "https" or "http", how the client reached the application,
+// the TrustedProxies in front of it telling through the Forwarded or
+// X-Forwarded-Proto headers
+func (r *Request) Scheme() string {
+	return r.forwarded().scheme
+}
+
+// ForwardedHost returns the host the client asked for, the TrustedProxies in front of
+// the application telling through the Forwarded or X-Forwarded-Host headers.
+// Request.Host is the one the application itself was asked for.
+func (r *Request) ForwardedHost() string {
+	return r.forwarded().host
+}
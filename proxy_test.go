@@ -0,0 +1,147 @@
+package frodo
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwarded(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted []string
+		remote  string
+		header  map[string]string
+
+		ip, scheme, host string
+	}{
+		{"no proxies trusted", nil, "203.0.113.7:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.com"},
+			"203.0.113.7", "http", "example.com"},
+		{"IPv6 peer", nil, "[2001:db8::1]:443", nil,
+			"2001:db8::1", "http", "example.com"},
+		{"IPv4-mapped IPv6 peer", nil, "[::ffff:203.0.113.7]:443", nil,
+			"203.0.113.7", "http", "example.com"},
+
+		// X-Forwarded-For
+		{"trusted proxy", []string{"10.0.0.0/8"}, "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "app.example.com"},
+			"198.51.100.1", "https", "app.example.com"},
+		{"trusted hops walked from the right", []string{"10.0.0.0/8"}, "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.3, 10.0.0.2"},
+			"198.51.100.1", "http", "example.com"},
+		{"several untrusted hops", []string{"10.0.0.0/8"}, "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "192.0.2.9, 198.51.100.2, 198.51.100.1"},
+			"198.51.100.1", "http", "example.com"},
+		{"hops without spaces", []string{"10.0.0.0/8"}, "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "192.0.2.9, 198.51.100.1,10.0.0.2"},
+			"198.51.100.1", "http", "example.com"},
+		{"every hop trusted", []string{"10.0.0.0/8"}, "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			"10.0.0.3", "http", "example.com"},
+		{"invalid hop", []string{"10.0.0.0/8"}, "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1, not-an-ip, 10.0.0.2"},
+			"10.0.0.2", "http", "example.com"},
+		{"spoofed by an untrusted peer", []string{"10.0.0.0/8"}, "203.0.113.7:5000",
+			map[string]string{"X-Forwarded-For": "10.0.0.2", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.com"},
+			"203.0.113.7", "http", "example.com"},
+		{"last proto and host", []string{"loopback"}, "127.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "http, https", "X-Forwarded-Host": "evil.com, app.example.com"},
+			"198.51.100.1", "https", "app.example.com"},
+		{"unknown proto", []string{"loopback"}, "127.0.0.1:5000",
+			map[string]string{"X-Forwarded-Proto": "gopher"},
+			"127.0.0.1", "http", "example.com"},
+		{"X-Real-Ip", []string{"loopback"}, "127.0.0.1:5000",
+			map[string]string{"X-Real-Ip": "198.51.100.1"},
+			"198.51.100.1", "http", "example.com"},
+		{"X-Forwarded-For over X-Real-Ip", []string{"loopback"}, "127.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-Ip": "192.0.2.9"},
+			"198.51.100.1", "http", "example.com"},
+
+		// IPv4-mapped IPv6 addresses are unmapped before they are checked
+		{"mapped peer, IPv4 proxies", []string{"10.0.0.0/8"}, "[::ffff:10.0.0.1]:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			"198.51.100.1", "http", "example.com"},
+		{"IPv4 peer, mapped proxies", []string{"::ffff:10.0.0.0/104"}, "10.1.2.3:5000",
+			map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			"198.51.100.1", "http", "example.com"},
+		{"mapped hops", []string{"10.0.0.0/8"}, "10.0.0.1:5000",
+			map[string]string{"X-Forwarded-For": "::ffff:198.51.100.1, ::ffff:10.0.0.2"},
+			"198.51.100.1", "http", "example.com"},
+		{"private keyword", []string{"private"}, "[fd00::1]:5000",
+			map[string]string{"X-Forwarded-For": "2001:db8::17"},
+			"2001:db8::17", "http", "example.com"},
+
+		// Forwarded, RFC 7239
+		{"Forwarded", []string{"loopback"}, "127.0.0.1:5000",
+			map[string]string{"Forwarded": "for=198.51.100.1;proto=https;host=app.example.com"},
+			"198.51.100.1", "https", "app.example.com"},
+		{"quoted IPv6 and port", []string{"loopback"}, "127.0.0.1:5000",
+			map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711";proto=https;host="app.example.com:8443"`},
+			"2001:db8:cafe::17", "https", "app.example.com:8443"},
+		{"IPv4 and port", []string{"loopback"}, "127.0.0.1:5000",
+			map[string]string{"Forwarded": `for="198.51.100.1:4711"`},
+			"198.51.100.1", "http", "example.com"},
+		{"case of the parameters", []string{"loopback"}, "127.0.0.1:5000",
+			map[string]string{"Forwarded": "For=198.51.100.1;Proto=HTTPS"},
+			"198.51.100.1", "https", "example.com"},
+		{"untrusted hop stops the walk", []string{"private"}, "192.168.1.1:5000",
+			map[string]string{"Forwarded": `for=192.0.2.9;proto=http;host=evil.com, for="[2001:db8::17]:4711";proto=https;host=shop.example.com, for=192.168.1.9`},
+			"2001:db8::17", "https", "shop.example.com"},
+		{"obfuscated node", []string{"10.0.0.1"}, "10.0.0.1:5000",
+			map[string]string{"Forwarded": "for=_hidden;proto=https"},
+			"10.0.0.1", "https", "example.com"},
+		{"unknown node", []string{"10.0.0.1"}, "10.0.0.1:5000",
+			map[string]string{"Forwarded": "for=unknown"},
+			"10.0.0.1", "http", "example.com"},
+		{"quoted separators", []string{"loopback"}, "127.0.0.1:5000",
+			map[string]string{"Forwarded": `for=198.51.100.1;host="a.example.com,b;c"`},
+			"198.51.100.1", "http", "a.example.com,b;c"},
+		{"Forwarded over X-Forwarded-For", []string{"loopback"}, "127.0.0.1:5000",
+			map[string]string{"Forwarded": "for=198.51.100.1", "X-Forwarded-For": "192.0.2.9"},
+			"198.51.100.1", "http", "example.com"},
+		{"Forwarded spoofed by an untrusted peer", []string{"loopback"}, "203.0.113.7:5000",
+			map[string]string{"Forwarded": "for=127.0.0.1;proto=https;host=evil.com"},
+			"203.0.113.7", "http", "example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := New()
+			app.TrustedProxies = test.trusted
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = test.remote
+			for name, value := range test.header {
+				req.Header.Set(name, value)
+			}
+
+			r := &Request{Request: req, router: app}
+			if ip := r.ClientIP(); ip != test.ip {
+				t.Errorf("ClientIP is %q, want %q", ip, test.ip)
+			}
+			if scheme := r.Scheme(); scheme != test.scheme {
+				t.Errorf("Scheme is %q, want %q", scheme, test.scheme)
+			}
+			if host := r.ForwardedHost(); host != test.host {
+				t.Errorf("ForwardedHost is %q, want %q", host, test.host)
+			}
+			if r.Host != "example.com" {
+				t.Errorf("Host is %q, the one the application was asked for", r.Host)
+			}
+		})
+	}
+}
+
+func TestForwardedOverTLS(t *testing.T) {
+	app := New()
+	app.TrustedProxies = []string{"loopback"}
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	req.RemoteAddr = "127.0.0.1:5000"
+	if r := (&Request{Request: req, router: app}); r.Scheme() != "https" {
+		t.Errorf("over TLS the scheme is %q", r.Scheme())
+	}
+
+	// a proxy may tell the client came over plain HTTP
+	req.Header.Set("X-Forwarded-Proto", "http")
+	if r := (&Request{Request: req, router: app}); r.Scheme() != "http" {
+		t.Errorf("behind a proxy the scheme is %q", r.Scheme())
+	}
+}
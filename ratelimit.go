@@ -0,0 +1,291 @@
+package frodo
+
+import (
+	"context"
+	"hash/maphash"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitAlgorithm is how requests are counted against a limit
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket lets a client spend the whole limit at once, the budget then
+	// refills evenly over the window
+	TokenBucket RateLimitAlgorithm = iota
+
+	// SlidingWindow counts the requests over the last window, weighing
+	// the previous window by how much of it still overlaps
+	SlidingWindow
+)
+
+// Rate is a number of requests allowed over a window, and how they are counted
+type Rate struct {
+	Limit     int
+	Window    time.Duration
+	Algorithm RateLimitAlgorithm
+}
+
+// RateLimitResult is what counting a request against a Rate came to
+type RateLimitResult struct {
+	// Allowed is false once the limit is reached
+	Allowed bool
+
+	// Remaining is the number of requests still allowed right away
+	Remaining int
+
+	// Reset is how long until the full limit is available again
+	Reset time.Duration
+
+	// RetryAfter is how long until a request is allowed again, zero when one is
+	RetryAfter time.Duration
+}
+
+// RateLimitStore counts the requests made under each key. MemoryRateLimitStore keeps
+// the counts in the process, a store backed by eg. Redis shares them between instances.
+type RateLimitStore interface {
+	// Take counts a request under the key against the rate
+	Take(ctx context.Context, key string, rate Rate) (RateLimitResult, error)
+}
+
+// RateLimitOptions configures RateLimit
+type RateLimitOptions struct {
+	// Limit is the number of requests allowed per Window
+	Limit  int
+	Window time.Duration
+
+	// Algorithm is TokenBucket by default
+	Algorithm RateLimitAlgorithm
+
+	// Key picks who the requests are counted for, Request.ClientIP by default.
+	// Requests it returns an empty key for are not limited.
+	Key func(r *Request) string
+
+	// Name sets the limiter's keys apart from those of others sharing the Store
+	Name string
+
+	// Store counts the requests, a new MemoryRateLimitStore if it is not set
+	Store RateLimitStore
+
+	// ErrorHandler answers the requests over the limit. The Router's
+	// TooManyRequestsHandler does if it is not set, or a plain 429.
+	ErrorHandler Handler
+}
+
+// RateLimit is middleware throttling requests. Every route it is used on shares
+// the same limit, so one limiter covers a group of routes, and one per route
+// gives each its own. The RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers tell the client how it is doing, and Retry-After how long to wait once
+// it is answered with 429 Too Many Requests.
+//
+//	perIP := frodo.RateLimit(frodo.RateLimitOptions{Limit: 100, Window: time.Minute})
+//	perKey := frodo.RateLimit(frodo.RateLimitOptions{
+//		Limit:     1000,
+//		Window:    time.Hour,
+//		Algorithm: frodo.SlidingWindow,
+//		Key: func(r *frodo.Request) string {
+//			return r.Header.Get("X-API-Key")
+//		},
+//	})
+//	app.Post("/login", perIP, LoginHandler)
+//	app.Get("/api/posts", perKey, PostsHandler)
+//
+// Should the store fail the request is let through, and the error logged.
+func RateLimit(opts RateLimitOptions) Handler {
+	if opts.Limit <= 0 || opts.Window <= 0 {
+		panic("Error: a RateLimit needs a Limit and a Window above zero")
+	}
+	if opts.Key == nil {
+		opts.Key = func(r *Request) string {
+			return r.ClientIP()
+		}
+	}
+	if opts.Store == nil {
+		opts.Store = NewMemoryRateLimitStore()
+	}
+	rate := Rate{Limit: opts.Limit, Window: opts.Window, Algorithm: opts.Algorithm}
+	policy := strconv.Itoa(opts.Limit) + ";w=" + strconv.Itoa(int(math.Ceil(opts.Window.Seconds())))
+
+	return func(w http.ResponseWriter, r *Request) {
+		key := opts.Key(r)
+		if key == "" {
+			r.Next()
+			return
+		}
+
+		result, err := opts.Store.Take(r.Context(), opts.Name+":"+key, rate)
+		if err != nil {
+			logf(r.writer.logger, "[ERROR] Rate limiting %s: %s", key, err)
+			r.Next()
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Policy", policy)
+		header.Set("RateLimit-Limit", strconv.Itoa(opts.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if result.Allowed {
+			r.Next()
+			return
+		}
+
+		header.Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+		switch {
+		case opts.ErrorHandler != nil:
+			opts.ErrorHandler(w, r)
+		case r.router != nil && r.router.TooManyRequestsHandler != nil:
+			r.router.TooManyRequestsHandler(w, r)
+		default:
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		}
+	}
+}
+
+// ceilSeconds rounds the duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// rateLimitShards is the number of locks a MemoryRateLimitStore spreads its keys over
+const rateLimitShards = 32
+
+// MemoryRateLimitStore counts requests in memory, the keys spread over shards so
+// requests for different keys seldom wait on each other. Keys idle for longer than
+// their window are evicted as others are counted.
+type MemoryRateLimitStore struct {
+	seed   maphash.Seed
+	shards [rateLimitShards]rateLimitShard
+
+	// now tells the time the requests are counted at, time.Now outside of tests
+	now func() time.Time
+}
+
+type rateLimitShard struct {
+	mu        sync.Mutex
+	entries   map[string]*rateLimitEntry
+	lastSweep time.Time
+}
+
+// rateLimitEntry is the count kept for a key, the fields used depend on the algorithm
+type rateLimitEntry struct {
+	window time.Duration
+	last   time.Time
+
+	// TokenBucket
+	tokens float64
+
+	// SlidingWindow
+	start         time.Time
+	current, prev int
+}
+
+// NewMemoryRateLimitStore returns an empty MemoryRateLimitStore
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{seed: maphash.MakeSeed(), now: time.Now}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*rateLimitEntry)
+	}
+	return s
+}
+
+// Take counts a request under the key against the rate
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, rate Rate) (RateLimitResult, error) {
+	shard := &s.shards[maphash.String(s.seed, key)%rateLimitShards]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(shard.lastSweep) > time.Minute {
+		for k, entry := range shard.entries {
+			if now.Sub(entry.last) > 2*entry.window {
+				delete(shard.entries, k)
+			}
+		}
+		shard.lastSweep = now
+	}
+
+	entry, ok := shard.entries[key]
+	if !ok {
+		entry = &rateLimitEntry{tokens: float64(rate.Limit), start: now}
+		shard.entries[key] = entry
+	}
+	entry.window = rate.Window
+	defer func() { entry.last = now }()
+
+	if rate.Algorithm == SlidingWindow {
+		return entry.slidingWindow(now, rate), nil
+	}
+	return entry.tokenBucket(now, rate), nil
+}
+
+// tokenBucket refills the bucket for the time passed, then takes a token from it
+func (e *rateLimitEntry) tokenBucket(now time.Time, rate Rate) RateLimitResult {
+	perSecond := float64(rate.Limit) / rate.Window.Seconds()
+	if !e.last.IsZero() {
+		e.tokens = math.Min(float64(rate.Limit), e.tokens+now.Sub(e.last).Seconds()*perSecond)
+	}
+
+	var result RateLimitResult
+	if e.tokens >= 1 {
+		e.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - e.tokens) / perSecond * float64(time.Second))
+	}
+	result.Remaining = int(e.tokens)
+	result.Reset = time.Duration((float64(rate.Limit) - e.tokens) / perSecond * float64(time.Second))
+	return result
+}
+
+// slidingWindow moves the windows along, then counts the request in the current one
+func (e *rateLimitEntry) slidingWindow(now time.Time, rate Rate) RateLimitResult {
+	if elapsed := now.Sub(e.start); elapsed >= rate.Window {
+		windows := int(elapsed / rate.Window)
+		if windows == 1 {
+			e.prev = e.current
+		} else {
+			e.prev = 0
+		}
+		e.current = 0
+		e.start = e.start.Add(time.Duration(windows) * rate.Window)
+	}
+
+	elapsed := now.Sub(e.start)
+	overlap := 1 - float64(elapsed)/float64(rate.Window)
+	count := float64(e.prev)*overlap + float64(e.current)
+
+	var result RateLimitResult
+	if count+1 <= float64(rate.Limit) {
+		e.current++
+		count++
+		result.Allowed = true
+	} else {
+		result.RetryAfter = e.retryAfter(elapsed, rate)
+	}
+	result.Remaining = max(rate.Limit-int(math.Ceil(count)), 0)
+	// the current window's requests weigh in until the end of the next one
+	result.Reset = 2*rate.Window - elapsed
+	if e.current == 0 {
+		result.Reset = rate.Window - elapsed
+	}
+	return result
+}
+
+// retryAfter works out when the weighted count drops enough to let a request through
+func (e *rateLimitEntry) retryAfter(elapsed time.Duration, rate Rate) time.Duration {
+	room := float64(rate.Limit - 1)
+	window := float64(rate.Window)
+	if e.current <= rate.Limit-1 && e.prev > 0 {
+		// within this window, as the previous one weighs less
+		wait := window*(1-(room-float64(e.current))/float64(e.prev)) - float64(elapsed)
+		return time.Duration(math.Max(wait, 0))
+	}
+	// in the next window, once this one weighs little enough
+	wait := float64(rate.Window-elapsed) + window*(1-room/float64(e.current))
+	return time.Duration(math.Max(wait, 0))
+}
@@ -0,0 +1,245 @@
+package frodo
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testClock is a clock that only moves when told to
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// newTestRateLimitStore returns a MemoryRateLimitStore counting on a testClock
+func newTestRateLimitStore() (*MemoryRateLimitStore, *testClock) {
+	clock := &testClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	store := NewMemoryRateLimitStore()
+	store.now = clock.Now
+	return store, clock
+}
+
+// rateLimitStep is a request counted after the clock moved on by advance
+type rateLimitStep struct {
+	advance time.Duration
+	want    RateLimitResult
+}
+
+func runRateLimitSteps(t *testing.T, rate Rate, steps []rateLimitStep) {
+	t.Helper()
+	store, clock := newTestRateLimitStore()
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		got, err := store.Take(context.Background(), "key", rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != step.want {
+			t.Errorf("request %d got %+v, want %+v", i+1, got, step.want)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	// a token every second
+	rate := Rate{Limit: 3, Window: 3 * time.Second, Algorithm: TokenBucket}
+	runRateLimitSteps(t, rate, []rateLimitStep{
+		// the whole limit at once
+		{0, RateLimitResult{Allowed: true, Remaining: 2, Reset: time.Second}},
+		{0, RateLimitResult{Allowed: true, Remaining: 1, Reset: 2 * time.Second}},
+		{0, RateLimitResult{Allowed: true, Remaining: 0, Reset: 3 * time.Second}},
+		{0, RateLimitResult{Remaining: 0, Reset: 3 * time.Second, RetryAfter: time.Second}},
+
+		// then refilled evenly
+		{500 * time.Millisecond, RateLimitResult{Remaining: 0, Reset: 2500 * time.Millisecond, RetryAfter: 500 * time.Millisecond}},
+		{500 * time.Millisecond, RateLimitResult{Allowed: true, Remaining: 0, Reset: 3 * time.Second}},
+		{2 * time.Second, RateLimitResult{Allowed: true, Remaining: 1, Reset: 2 * time.Second}},
+
+		// never beyond the limit
+		{time.Hour, RateLimitResult{Allowed: true, Remaining: 2, Reset: time.Second}},
+	})
+}
+
+func TestSlidingWindow(t *testing.T) {
+	rate := Rate{Limit: 4, Window: 10 * time.Second, Algorithm: SlidingWindow}
+	runRateLimitSteps(t, rate, []rateLimitStep{
+		{0, RateLimitResult{Allowed: true, Remaining: 3, Reset: 20 * time.Second}},
+		{0, RateLimitResult{Allowed: true, Remaining: 2, Reset: 20 * time.Second}},
+		{0, RateLimitResult{Allowed: true, Remaining: 1, Reset: 20 * time.Second}},
+		{0, RateLimitResult{Allowed: true, Remaining: 0, Reset: 20 * time.Second}},
+		// the 4 requests weigh 3 a quarter into the next window
+		{0, RateLimitResult{Remaining: 0, Reset: 20 * time.Second, RetryAfter: 12500 * time.Millisecond}},
+		{5 * time.Second, RateLimitResult{Remaining: 0, Reset: 15 * time.Second, RetryAfter: 7500 * time.Millisecond}},
+
+		// a quarter into the next window: 4*0.75 + 1
+		{7500 * time.Millisecond, RateLimitResult{Allowed: true, Remaining: 0, Reset: 17500 * time.Millisecond}},
+		// half way: 4*0.5 + 1 + 1
+		{0, RateLimitResult{Remaining: 0, Reset: 17500 * time.Millisecond, RetryAfter: 2500 * time.Millisecond}},
+		{2500 * time.Millisecond, RateLimitResult{Allowed: true, Remaining: 0, Reset: 15 * time.Second}},
+
+		// windows long gone are forgotten
+		{time.Minute, RateLimitResult{Allowed: true, Remaining: 3, Reset: 20 * time.Second}},
+	})
+}
+
+func TestMemoryRateLimitStoreEviction(t *testing.T) {
+	store, clock := newTestRateLimitStore()
+	rate := Rate{Limit: 1, Window: time.Second}
+	shardOf := func(key string) int {
+		return int(maphash.String(store.seed, key) % rateLimitShards)
+	}
+	// another key counted in the same shard sweeps it
+	other := ""
+	for i := 0; other == ""; i++ {
+		if key := strconv.Itoa(i); shardOf(key) == shardOf("idle") && key != "idle" {
+			other = key
+		}
+	}
+
+	store.Take(context.Background(), "idle", rate)
+	clock.Advance(2 * time.Minute)
+	store.Take(context.Background(), other, rate)
+
+	shard := &store.shards[shardOf("idle")]
+	if _, ok := shard.entries["idle"]; ok {
+		t.Error("an idle key was not evicted")
+	}
+	if _, ok := shard.entries[other]; !ok {
+		t.Error("the key counted was evicted")
+	}
+}
+
+// failingRateLimitStore fails every count
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(ctx context.Context, key string, rate Rate) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("store unavailable")
+}
+
+func TestRateLimit(t *testing.T) {
+	store, clock := newTestRateLimitStore()
+	app := New()
+	perIP := RateLimit(RateLimitOptions{Limit: 2, Window: time.Minute, Store: store})
+	perKey := RateLimit(RateLimitOptions{
+		Limit:  1,
+		Window: time.Minute,
+		Name:   "key",
+		Store:  store,
+		Key: func(r *Request) string {
+			return r.Header.Get("X-API-Key")
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *Request) {
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		},
+	})
+	failing := RateLimit(RateLimitOptions{Limit: 1, Window: time.Minute, Store: failingRateLimitStore{}})
+	ok := func(w http.ResponseWriter, r *Request) {
+		w.Write([]byte("ok"))
+	}
+	app.Get("/ip", perIP, ok)
+	app.Get("/ip/other", perIP, ok)
+	app.Get("/key", perKey, ok)
+	app.Get("/failing", failing, ok)
+	app.TooManyRequests(func(w http.ResponseWriter, r *Request) {
+		http.Error(w, "too many", http.StatusTooManyRequests)
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		ip      string
+		apiKey  string
+		advance time.Duration
+
+		status int
+		body   string
+		header map[string]string
+	}{
+		{"first", "/ip", "192.0.2.1", "", 0, http.StatusOK, "ok", map[string]string{
+			"RateLimit-Policy":    "2;w=60",
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": "1",
+			"RateLimit-Reset":     "30",
+			"Retry-After":         "",
+		}},
+		{"shared by the routes", "/ip/other", "192.0.2.1", "", 0, http.StatusOK, "ok", map[string]string{
+			"RateLimit-Remaining": "0",
+			"RateLimit-Reset":     "60",
+		}},
+		{"over the limit", "/ip", "192.0.2.1", "", 0, http.StatusTooManyRequests, "too many", map[string]string{
+			"RateLimit-Remaining": "0",
+			"RateLimit-Reset":     "60",
+			"Retry-After":         "30",
+		}},
+		{"another client", "/ip", "192.0.2.2", "", 0, http.StatusOK, "ok", map[string]string{
+			"RateLimit-Remaining": "1",
+		}},
+		{"refilled", "/ip", "192.0.2.1", "", 30 * time.Second, http.StatusOK, "ok", map[string]string{
+			"RateLimit-Remaining": "0",
+			"RateLimit-Reset":     "60",
+		}},
+
+		{"key", "/key", "192.0.2.1", "secret", 0, http.StatusOK, "ok", map[string]string{
+			"RateLimit-Policy":    "1;w=60",
+			"RateLimit-Remaining": "0",
+		}},
+		{"key over the limit", "/key", "192.0.2.3", "secret", 0, http.StatusTooManyRequests, "slow down", map[string]string{
+			"Retry-After": "60",
+		}},
+		{"another key", "/key", "192.0.2.1", "other", 0, http.StatusOK, "ok", nil},
+		{"no key", "/key", "192.0.2.1", "", 0, http.StatusOK, "ok", map[string]string{
+			"RateLimit-Limit": "",
+		}},
+
+		{"store failing", "/failing", "192.0.2.1", "", 0, http.StatusOK, "ok", map[string]string{
+			"RateLimit-Limit": "",
+		}},
+	}
+	for _, test := range tests {
+		clock.Advance(test.advance)
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.RemoteAddr = test.ip + ":5000"
+		if test.apiKey != "" {
+			req.Header.Set("X-API-Key", test.apiKey)
+		}
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+
+		if body := rec.Body.String(); rec.Code != test.status || (body != test.body && body != test.body+"\n") {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, rec.Code, body, test.status, test.body)
+		}
+		for name, want := range test.header {
+			if got := rec.Header().Get(name); got != want {
+				t.Errorf("%s: %s is %q, want %q", test.name, name, got, want)
+			}
+		}
+	}
+}
+
+func TestRateLimitRetryAfterAtLeastASecond(t *testing.T) {
+	store, _ := newTestRateLimitStore()
+	app := New()
+	app.Get("/", RateLimit(RateLimitOptions{Limit: 100, Window: time.Second, Store: store}), func(w http.ResponseWriter, r *Request) {})
+
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < 101; i++ {
+		rec = httptest.NewRecorder()
+		app.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	}
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("got %d with Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
@@ -1,13 +1,31 @@
 package frodo
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
 	"net/http"
-	"strings"
+	"sync"
+
+	"github.com/kn9ts/frodo/sessions"
 )
 
 // Request will help facilitate the passing of multiple handlers
 type Request struct {
-	files []*UploadedFile
+	files     []*UploadedFile
+	router    *Router
+	writer    *ResponseWriter
+	route     *route
+	csrf      *csrf
+	csrfToken string
+	csrfErr   error
+	session   *sessions.Session
+	flashes   map[string][]string
+	oldInput  map[string][]string
+	flashed   bool
+	proxied   *forwarded
+	stream    *EventStream
 	*http.Request
 	*RequestMiddleware
 	Params
@@ -51,67 +69,186 @@ func (r *Request) HasFile(name string) bool {
 func (r *Request) UploadedFile(name string) (*UploadedFile, error) {
 	file, header, err := r.FormFile(name)
 	if err == nil {
-		return &UploadedFile{file, header}, nil
+		return r.uploadedFile(file, header), nil
 	}
 	return nil, err
 }
 
 // UploadedFiles parses all uploaded files, creates and returns an array of UploadedFile
-// type representing each uploaded file
+// type representing each file uploaded under the given name. The files are also
+// kept for MoveAll. The whole form is buffered, see Multipart to stream it instead.
 func (r *Request) UploadedFiles(name string) []*UploadedFile {
-	// Instantiate r.files
-	if r.files == nil {
-		r.files = make([]*UploadedFile, len(r.MultipartForm.File[name]))
-		r.ParseMultipartForm(32 << 20)
+	if r.MultipartForm == nil {
+		r.ParseMultipartForm(defaultMaxMemory)
 	}
 
-	for _, header := range r.MultipartForm.File[name] {
-		file, _ := header.Open()
-		r.files = append(r.files, &UploadedFile{file, header})
+	var files []*UploadedFile
+	for _, header := range r.uploadedHeaders(name) {
+		file, err := header.Open()
+		if err != nil {
+			continue
+		}
+		files = append(files, r.uploadedFile(file, header))
 	}
 
-	return r.files
+	// remember them for MoveAll, once
+	for _, file := range files {
+		seen := false
+		for _, kept := range r.files {
+			if kept.FileHeader == file.FileHeader {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			r.files = append(r.files, file)
+		}
+	}
+	return files
+}
+
+// ErrRolledBack is the error given to the files MoveAll stored and then
+// deleted again, because another file failed in an all-or-nothing move
+var ErrRolledBack = errors.New("frodo: move rolled back")
+
+// DefaultMoveWorkers is how many files MoveAll moves at the same time
+var DefaultMoveWorkers = 4
+
+// MoveOptions changes how MoveAll goes about moving the files, pass it
+// along with the arguments meant for each file's Move
+type MoveOptions struct {
+	// Workers caps how many files are moved at the same time, DefaultMoveWorkers if zero
+	Workers int
+
+	// AllOrNothing deletes the files already stored when any of them fails
+	AllOrNothing bool
+}
+
+// MoveResult is the outcome of moving one of the files
+type MoveResult struct {
+	File   *UploadedFile
+	Stored *StoredFile
+	Err    error
 }
 
 // MoveAll is a neat trick to upload all the files that
 // have been parsed. Awesome for bulk uploading, and storage.
-func (r *Request) MoveAll(args ...interface{}) (bool, int) {
-	if r.files == nil {
-		return false, 0
+// The files are moved concurrently, the arguments are handed to each
+// file's Move, apart from MoveOptions. A result is returned for every file,
+// in the order they were parsed, along with the errors joined together.
+// Once the request's context is done the files not yet moved are skipped.
+//
+//	results, err := r.MoveAll(storage, frodo.MoveOptions{AllOrNothing: true})
+func (r *Request) MoveAll(args ...interface{}) ([]MoveResult, error) {
+	var opts MoveOptions
+	var moveArgs []interface{}
+	for _, arg := range args {
+		if value, ok := arg.(MoveOptions); ok {
+			opts = value
+			continue
+		}
+		moveArgs = append(moveArgs, arg)
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultMoveWorkers
 	}
 
-	count := 0
-	for _, file := range r.files {
-		moved := file.Move(args...)
-		if moved {
-			count++
+	// the keys are worked out before any file is stored, files meant for the same
+	// key are then moved one after the other, in the order they were parsed, so
+	// each sees the ones before it when the CollisionPolicy checks the key
+	ctx := r.Context()
+	results := make([]MoveResult, len(r.files))
+	var batches [][]int
+	batchOf := make(map[string]int)
+	targets := make([]moveTarget, len(r.files))
+	for i, file := range r.files {
+		results[i].File = file
+		target, err := file.target(moveArgs)
+		if err != nil {
+			file.Close()
+			results[i].Err = err
+			continue
 		}
+		targets[i] = target
+
+		key := cleanKey(target.key)
+		n, ok := batchOf[key]
+		if !ok {
+			n = len(batches)
+			batchOf[key] = n
+			batches = append(batches, nil)
+		}
+		batches[n] = append(batches[n], i)
 	}
 
-	if count == len(r.files) {
-		return true, count
+	queue := make(chan []int)
+	var wg sync.WaitGroup
+	for n := 0; n < workers && n < len(batches); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range queue {
+				for _, i := range batch {
+					file := r.files[i]
+					if err := ctx.Err(); err != nil {
+						file.Close()
+						results[i].Err = err
+						continue
+					}
+					results[i].Stored, results[i].Err = file.store(targets[i])
+					file.Close()
+				}
+			}
+		}()
 	}
-	return false, count
-}
+	for _, batch := range batches {
+		queue <- batch
+	}
+	close(queue)
+	wg.Wait()
 
-// ClientIP implements a best effort algorithm to return the real client IP, it parses
-// X-Real-IP and X-Forwarded-For in order to work properly with reverse-proxies such us: nginx or haproxy.
-func (r *Request) ClientIP() string {
-	if true {
-		clientIP := strings.TrimSpace(r.Request.Header.Get("X-Real-Ip"))
-		if len(clientIP) > 0 {
-			return clientIP
-		}
-		clientIP = r.Request.Header.Get("X-Forwarded-For")
-		if index := strings.IndexByte(clientIP, ','); index >= 0 {
-			clientIP = clientIP[0:index]
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", SanitizeFilename(result.File.Name()), result.Err))
 		}
-		clientIP = strings.TrimSpace(clientIP)
-		if len(clientIP) > 0 {
-			return clientIP
+	}
+
+	if len(errs) > 0 && opts.AllOrNothing {
+		// the request may be what failed, deleting must still go through
+		cleanup := context.WithoutCancel(ctx)
+		for i, result := range results {
+			if result.Stored == nil {
+				continue
+			}
+			if err := result.Stored.Storage.Delete(cleanup, result.Stored.Key); err != nil {
+				errs = append(errs, fmt.Errorf("%s: rolling back: %w", SanitizeFilename(result.File.Name()), err))
+			}
+			results[i].Stored = nil
+			results[i].Err = ErrRolledBack
 		}
 	}
-	return strings.TrimSpace(r.Request.RemoteAddr)
+	return results, errors.Join(errs...)
+}
+
+// uploadedFile wraps a file sent with the request, it is stored
+// in the Router's Storage unless told otherwise
+func (r *Request) uploadedFile(file multipart.File, header *multipart.FileHeader) *UploadedFile {
+	uploaded := &UploadedFile{File: file, FileHeader: header, ctx: r.Context()}
+	if r.router != nil {
+		uploaded.storage = r.router.Storage
+		uploaded.naming = r.router.UploadNaming
+		uploaded.collision = r.router.UploadCollision
+	}
+	return uploaded
+}
+
+// ClientIP returns the IP of the client, without the port. Behind reverse proxies
+// listed in the Router's TrustedProxies it is the one they forwarded the request
+// for, see Router.TrustedProxies.
+func (r *Request) ClientIP() string {
+	return r.forwarded().ip
 }
 
 // IsAjax checks if the Request was made via AJAX,
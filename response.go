@@ -3,83 +3,478 @@ package frodo
 import (
 	"bufio"
 	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net"
 	"net/http"
+	"runtime"
+	"strings"
 	"time"
+)
 
-	"log"
+// ErrResponseFinished is returned when writing to a response whose handlers have
+// all returned, or whose connection has been hijacked
+var ErrResponseFinished = errors.New("frodo: response already finished")
+
+// ResponseState is the stage of its lifecycle a response is at,
+// it only ever moves forward
+type ResponseState int
+
+const (
+	// HeadersPending means nothing has been sent, headers can still be changed
+	HeadersPending ResponseState = iota
+	// HeadersCommitted means the status and headers have been sent
+	HeadersCommitted
+	// BodyStreaming means part of the body has been sent
+	BodyStreaming
+	// ResponseFinished means the handlers have returned or the connection
+	// was hijacked, nothing more can be written
+	ResponseFinished
 )
 
-const customErrorMessage string = "[ERROR] Headers were already written."
+func (s ResponseState) String() string {
+	switch s {
+	case HeadersPending:
+		return "headers pending"
+	case HeadersCommitted:
+		return "headers committed"
+	case BodyStreaming:
+		return "body streaming"
+	case ResponseFinished:
+		return "finished"
+	}
+	return "unknown"
+}
 
 // ResponseWriter is used to hijack/embed http.ResponseWriter
-// thus making it satisfy the ResponseWriter interface, we then add a written boolean property
-// to trace when a write made, with a couple of other helpful properties
+// thus making it satisfy the ResponseWriter interface, we then track
+// the state of the response, with a couple of other helpful properties
 type ResponseWriter struct {
 	http.ResponseWriter
-	headerWritten bool
-	written       bool
-	timeStart     time.Time
-	timeEnd       time.Time
-	duration      float64
-	statusCode    int
-	size          int64
-	method        string
-	route         string
+	state      ResponseState
+	hooks      []func(*ResponseWriter, int)
+	logger     *log.Logger
+	timeStart  time.Time
+	timeEnd    time.Time
+	duration   float64
+	statusCode int
+	size       int64
+	method     string
+	route      string
+	request    *http.Request
+
+	// outer is the ResponseWriter as handlers are given it
+	outer http.ResponseWriter
 }
 
 // Write writes data back the client/creates the body
 func (w *ResponseWriter) Write(bytes []byte) (int, error) {
+	if w.state == ResponseFinished {
+		w.diagnose("Write of %d bytes after the response finished", len(bytes))
+		return 0, ErrResponseFinished
+	}
 	if !w.HeaderWritten() {
 		w.WriteHeader(http.StatusOK)
 	}
 
-	if w.ResponseSent() {
-		log.Println(customErrorMessage)
-		return 1, errors.New(customErrorMessage)
-	}
-
 	sent, err := w.ResponseWriter.Write(bytes)
-	if err != nil {
-		return sent, err
-	}
+	w.state = BodyStreaming
 	w.size += int64(sent)
 	w.timeEnd = time.Now()
-	w.duration = time.Since(w.timeEnd).Seconds()
-	return sent, nil
+	w.duration = w.timeEnd.Sub(w.timeStart).Seconds()
+	return sent, err
 }
 
-// WriteHeader writes the Headers out
+// WriteHeader writes the Headers out, running the BeforeWriteHeader hooks first.
+// Informational 1xx codes are passed straight through without committing the response.
 func (w *ResponseWriter) WriteHeader(code int) {
 	if w.HeaderWritten() {
-		log.Println(customErrorMessage)
+		w.diagnose("superfluous WriteHeader(%d), status %d was already sent", code, w.statusCode)
 		return
 	}
-	w.ResponseWriter.WriteHeader(code)
-	w.headerWritten = true
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	// commit before running the hooks so that a hook
+	// writing to the response cannot recurse back in here
+	w.state = HeadersCommitted
 	w.statusCode = code
+	for _, hook := range w.hooks {
+		hook(w, code)
+	}
+	w.ResponseWriter.WriteHeader(code)
 }
 
-// ResponseSent checks if a write has been made
-// starts with a header being sent out
+// BeforeWriteHeader registers a hook that runs just before the status and headers
+// are sent, in the order the hooks were registered. Hooks can change w.Header(),
+// but must not write to the response.
+func (w *ResponseWriter) BeforeWriteHeader(hook func(w *ResponseWriter, code int)) {
+	w.hooks = append(w.hooks, hook)
+}
+
+// Trailer sets a trailer, a header sent after the body. It can be called once the
+// body is being written, over HTTP/1.1 the response is then sent chunked.
+//
+//	w.Write(report)
+//	r.Writer().Trailer("X-Checksum", checksum)
+func (w *ResponseWriter) Trailer(key, value string) {
+	w.Header().Set(http.TrailerPrefix+key, value)
+}
+
+// State returns the stage of its lifecycle the response is at
+func (w *ResponseWriter) State() ResponseState {
+	return w.state
+}
+
+// Status returns the status code sent, zero if the headers are still pending
+func (w *ResponseWriter) Status() int {
+	return w.statusCode
+}
+
+// ResponseSent checks if a part of the body has been written,
+// or the response has finished
 func (w *ResponseWriter) ResponseSent() bool {
-	return w.written
+	return w.state >= BodyStreaming
 }
 
 // HeaderWritten checks if a write has been made
 // starts with a header being sent out
 func (w *ResponseWriter) HeaderWritten() bool {
-	return w.headerWritten
+	return w.state >= HeadersCommitted
+}
+
+// finish marks the response as done once the handlers have returned
+func (w *ResponseWriter) finish() {
+	w.state = ResponseFinished
+	if w.timeEnd.IsZero() {
+		w.timeEnd = time.Now()
+		w.duration = w.timeEnd.Sub(w.timeStart).Seconds()
+	}
+}
+
+// diagnose logs a misuse of the response, pointing at
+// the code outside of frodo and net/http that caused it
+func (w *ResponseWriter) diagnose(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	caller := "unknown caller"
+
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "net/http.") &&
+			!strings.Contains(frame.Function, "frodo.(*ResponseWriter)") {
+			caller = fmt.Sprintf("%s:%d", frame.File, frame.Line)
+			break
+		}
+		if !more {
+			break
+		}
+	}
+
+	logf(w.logger, "[ERROR] %s on %s %s (%s) at %s", msg, w.method, w.route, w.state, caller)
+}
+
+// Make sure the ResponseWriter exposes the optional interfaces of http.ResponseWriter,
+// each of them reports http.ErrNotSupported when the wrapped writer lacks it. Handlers
+// are not given the ResponseWriter itself but a wrapper around it with only those the
+// wrapped writer supports, so that eg. w.(http.Flusher) tells if the response can flush.
+var (
+	_ http.Hijacker = &ResponseWriter{}
+	_ http.Flusher  = &ResponseWriter{}
+	_ http.Pusher   = &ResponseWriter{}
+	_ io.ReaderFrom = &ResponseWriter{}
+)
+
+// responseWriter is what every handler's ResponseWriter has, whatever the wrapped writer supports
+type responseWriter interface {
+	http.ResponseWriter
+	http.CloseNotifier
+	BeforeWriteHeader(hook func(w *ResponseWriter, code int))
+	Trailer(key, value string)
+	State() ResponseState
+	Status() int
+	ResponseSent() bool
+	HeaderWritten() bool
+	Size() int64
+	Unwrap() http.ResponseWriter
+}
+
+// flushWriter is http.Flusher along with the FlushError http.ResponseController prefers
+type flushWriter interface {
+	http.Flusher
+	FlushError() error
+}
+
+// The ResponseWriter as handlers are given it, one type for each combination
+// of the optional interfaces: Flusher, Hijacker, Pusher and ReaderFrom
+type (
+	rw  struct{ responseWriter }
+	rwF struct {
+		responseWriter
+		flushWriter
+	}
+	rwH struct {
+		responseWriter
+		http.Hijacker
+	}
+	rwFH struct {
+		responseWriter
+		flushWriter
+		http.Hijacker
+	}
+	rwP struct {
+		responseWriter
+		http.Pusher
+	}
+	rwFP struct {
+		responseWriter
+		flushWriter
+		http.Pusher
+	}
+	rwHP struct {
+		responseWriter
+		http.Hijacker
+		http.Pusher
+	}
+	rwFHP struct {
+		responseWriter
+		flushWriter
+		http.Hijacker
+		http.Pusher
+	}
+	rwR struct {
+		responseWriter
+		io.ReaderFrom
+	}
+	rwFR struct {
+		responseWriter
+		flushWriter
+		io.ReaderFrom
+	}
+	rwHR struct {
+		responseWriter
+		http.Hijacker
+		io.ReaderFrom
+	}
+	rwFHR struct {
+		responseWriter
+		flushWriter
+		http.Hijacker
+		io.ReaderFrom
+	}
+	rwPR struct {
+		responseWriter
+		http.Pusher
+		io.ReaderFrom
+	}
+	rwFPR struct {
+		responseWriter
+		flushWriter
+		http.Pusher
+		io.ReaderFrom
+	}
+	rwHPR struct {
+		responseWriter
+		http.Hijacker
+		http.Pusher
+		io.ReaderFrom
+	}
+	rwFHPR struct {
+		responseWriter
+		flushWriter
+		http.Hijacker
+		http.Pusher
+		io.ReaderFrom
+	}
+)
+
+// the optional interfaces a wrapped writer can support
+const (
+	canFlush = 1 << iota
+	canHijack
+	canPush
+	canReadFrom
+)
+
+// handlerWriter returns the ResponseWriter as handlers are given it, a wrapper
+// with only the optional interfaces the wrapped writer supports
+func (w *ResponseWriter) handlerWriter() http.ResponseWriter {
+	if w.outer != nil {
+		return w.outer
+	}
+
+	var can int
+	if findWriter(w.ResponseWriter, func(writer http.ResponseWriter) bool {
+		_, flusher := writer.(http.Flusher)
+		_, flushErrorer := writer.(interface{ FlushError() error })
+		return flusher || flushErrorer
+	}) != nil {
+		can |= canFlush
+	}
+	if findWriter(w.ResponseWriter, func(writer http.ResponseWriter) bool {
+		_, ok := writer.(http.Hijacker)
+		return ok
+	}) != nil {
+		can |= canHijack
+	}
+	if findWriter(w.ResponseWriter, func(writer http.ResponseWriter) bool {
+		_, ok := writer.(http.Pusher)
+		return ok
+	}) != nil {
+		can |= canPush
+	}
+	if _, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		can |= canReadFrom
+	}
+
+	switch can {
+	case 0:
+		w.outer = rw{w}
+	case canFlush:
+		w.outer = rwF{w, w}
+	case canHijack:
+		w.outer = rwH{w, w}
+	case canFlush | canHijack:
+		w.outer = rwFH{w, w, w}
+	case canPush:
+		w.outer = rwP{w, w}
+	case canFlush | canPush:
+		w.outer = rwFP{w, w, w}
+	case canHijack | canPush:
+		w.outer = rwHP{w, w, w}
+	case canFlush | canHijack | canPush:
+		w.outer = rwFHP{w, w, w, w}
+	case canReadFrom:
+		w.outer = rwR{w, w}
+	case canFlush | canReadFrom:
+		w.outer = rwFR{w, w, w}
+	case canHijack | canReadFrom:
+		w.outer = rwHR{w, w, w}
+	case canFlush | canHijack | canReadFrom:
+		w.outer = rwFHR{w, w, w, w}
+	case canPush | canReadFrom:
+		w.outer = rwPR{w, w, w}
+	case canFlush | canPush | canReadFrom:
+		w.outer = rwFPR{w, w, w, w}
+	case canHijack | canPush | canReadFrom:
+		w.outer = rwHPR{w, w, w, w}
+	default:
+		w.outer = rwFHPR{w, w, w, w, w}
+	}
+	return w.outer
+}
+
+// Writer returns the ResponseWriter of the request's response, with all its methods,
+// eg. Attachment or Trailer. The one handlers are given wraps it, with only the
+// optional interfaces of http.ResponseWriter the connection supports.
+func (r *Request) Writer() *ResponseWriter {
+	return r.writer
+}
+
+// findWriter walks the writers wrapping each other through their Unwrap method,
+// eg. those of other middleware, returning the first one the check holds for
+func findWriter(w http.ResponseWriter, check func(http.ResponseWriter) bool) http.ResponseWriter {
+	for w != nil {
+		if check(w) {
+			return w
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = unwrapper.Unwrap()
+	}
+	return nil
 }
 
 // Hijack wraps response writer's Hijack function.
+// An error is returned when the wrapped writer cannot be hijacked, eg. over HTTP/2
 func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	return w.ResponseWriter.(http.Hijacker).Hijack()
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.state = ResponseFinished
+	}
+	return conn, brw, err
+}
+
+// Flush sends any buffered data out to the client,
+// writing the headers first if that has not happened yet.
+// A failure to flush is logged, FlushError returns it instead.
+func (w *ResponseWriter) Flush() {
+	if err := w.FlushError(); err != nil {
+		w.diagnose("Flush failed: %s", err)
+	}
+}
+
+// FlushError is Flush, reporting http.ErrNotSupported when the wrapped writer cannot flush.
+// It is what http.ResponseController uses.
+func (w *ResponseWriter) FlushError() error {
+	if !w.HeaderWritten() {
+		w.WriteHeader(http.StatusOK)
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Push wraps response writer's Push function, used for HTTP/2 server push
+func (w *ResponseWriter) Push(target string, opts *http.PushOptions) error {
+	writer := findWriter(w.ResponseWriter, func(writer http.ResponseWriter) bool {
+		_, ok := writer.(http.Pusher)
+		return ok
+	})
+	if writer == nil {
+		return http.ErrNotSupported
+	}
+	return writer.(http.Pusher).Push(target, opts)
 }
 
-// CloseNotify wraps response writer's CloseNotify function.
+// ReadFrom copies the reader into the response body, letting the wrapped writer
+// use sendfile(2) when it can while keeping the size of the response accounted for
+func (w *ResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if w.state == ResponseFinished {
+		w.diagnose("ReadFrom after the response finished")
+		return 0, ErrResponseFinished
+	}
+	if !w.HeaderWritten() {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if readerFrom, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err := readerFrom.ReadFrom(src)
+		w.state = BodyStreaming
+		w.size += n
+		w.timeEnd = time.Now()
+		w.duration = w.timeEnd.Sub(w.timeStart).Seconds()
+		return n, err
+	}
+	// hide ReadFrom so that io.Copy does not call back into it
+	return io.Copy(struct{ io.Writer }{w}, src)
+}
+
+// CloseNotify wraps response writer's CloseNotify function. If the wrapped writer
+// cannot notify, the channel fires once the request's Context is done, which is
+// what to prefer anyway, it is cancelled when the client goes away
 func (w *ResponseWriter) CloseNotify() <-chan bool {
-	return w.ResponseWriter.(http.CloseNotifier).CloseNotify()
+	if notifier, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	gone := make(chan bool, 1)
+	if w.request != nil {
+		go func() {
+			<-w.request.Context().Done()
+			gone <- true
+		}()
+	}
+	return gone
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, letting
+// http.ResponseController reach the features it has
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 // Size returns the size of the response
@@ -0,0 +1,115 @@
+package frodo
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// optionalInterfaces lists the optional interfaces of http.ResponseWriter w has
+func optionalInterfaces(w http.ResponseWriter) map[string]bool {
+	_, flusher := w.(http.Flusher)
+	_, hijacker := w.(http.Hijacker)
+	_, pusher := w.(http.Pusher)
+	_, readerFrom := w.(io.ReaderFrom)
+	return map[string]bool{"Flusher": flusher, "Hijacker": hijacker, "Pusher": pusher, "ReaderFrom": readerFrom}
+}
+
+// bareWriter is a ResponseWriter with none of the optional interfaces
+type bareWriter struct {
+	http.ResponseWriter
+}
+
+// wrappingWriter wraps another writer, as middleware does, reaching it through Unwrap
+type wrappingWriter struct {
+	http.ResponseWriter
+}
+
+func (w wrappingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func TestResponseWriterOptionalInterfaces(t *testing.T) {
+	tests := []struct {
+		name   string
+		writer func() http.ResponseWriter
+		want   map[string]bool
+	}{
+		{
+			name:   "recorder",
+			writer: func() http.ResponseWriter { return httptest.NewRecorder() },
+			want:   map[string]bool{"Flusher": true},
+		},
+		{
+			name:   "bare",
+			writer: func() http.ResponseWriter { return bareWriter{httptest.NewRecorder()} },
+			want:   map[string]bool{},
+		},
+		{
+			name:   "unwrapped",
+			writer: func() http.ResponseWriter { return wrappingWriter{httptest.NewRecorder()} },
+			want:   map[string]bool{"Flusher": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]bool
+			app := New()
+			app.Get("/", func(w http.ResponseWriter, r *Request) {
+				got = optionalInterfaces(w)
+			})
+			app.ServeHTTP(tt.writer(), httptest.NewRequest("GET", "/", nil))
+			for name, has := range got {
+				if has != tt.want[name] {
+					t.Errorf("%s: got %v, want %v", name, has, tt.want[name])
+				}
+			}
+		})
+	}
+}
+
+func TestResponseWriterOverHTTP1(t *testing.T) {
+	got := make(chan map[string]bool, 1)
+	app := New()
+	app.Get("/", func(w http.ResponseWriter, r *Request) {
+		got <- optionalInterfaces(w)
+	})
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	want := map[string]bool{"Flusher": true, "Hijacker": true, "Pusher": false, "ReaderFrom": true}
+	for name, has := range <-got {
+		if has != want[name] {
+			t.Errorf("%s: got %v, want %v", name, has, want[name])
+		}
+	}
+}
+
+func TestResponseWriterCloseNotify(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	notified := make(chan bool, 1)
+	app := New()
+	app.Get("/", func(w http.ResponseWriter, r *Request) {
+		gone := w.(http.CloseNotifier).CloseNotify()
+		cancel()
+		select {
+		case <-gone:
+			notified <- true
+		case <-time.After(time.Second):
+			notified <- false
+		}
+	})
+	app.ServeHTTP(bareWriter{httptest.NewRecorder()}, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	if !<-notified {
+		t.Fatal("CloseNotify did not fire once the request's context was done")
+	}
+}
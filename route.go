@@ -0,0 +1,71 @@
+package frodo
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// route describes the method, path pattern and optional name
+// a chain of handlers was registered with
+type route struct {
+	method, pattern, name string
+}
+
+// URL builds the path of a named route, filling in its parameters
+// from the key/value pairs given
+//
+//	eg. r.Get("/posts/:id", frodo.Attributes{Name: "posts.show"}, showPost)
+//	    r.URL("posts.show", "id", 42) // "/posts/42"
+func (r *Router) URL(name string, pairs ...interface{}) (string, error) {
+	rt, ok := r.routes[name]
+	if !ok {
+		return "", fmt.Errorf("frodo: no route named %q", name)
+	}
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("frodo: route %q expects key/value pairs, got %d arguments", name, len(pairs))
+	}
+
+	values := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		values[fmt.Sprint(pairs[i])] = fmt.Sprint(pairs[i+1])
+	}
+
+	segments := strings.Split(rt.pattern, "/")
+	for i, segment := range segments {
+		if len(segment) == 0 || (segment[0] != ':' && segment[0] != '*') {
+			continue
+		}
+		value, ok := values[segment[1:]]
+		if !ok {
+			return "", fmt.Errorf("frodo: route %q is missing the %q parameter", name, segment[1:])
+		}
+		if segment[0] == '*' {
+			// catch-all parameters keep their slashes
+			parts := strings.Split(strings.TrimPrefix(value, "/"), "/")
+			for j := range parts {
+				parts[j] = url.PathEscape(parts[j])
+			}
+			segments[i] = strings.Join(parts, "/")
+			continue
+		}
+		segments[i] = url.PathEscape(value)
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// RouteName returns the name the matched route was registered with
+func (r *Request) RouteName() string {
+	if r.route == nil {
+		return ""
+	}
+	return r.route.name
+}
+
+// RoutePattern returns the path pattern of the matched route eg. "/posts/:id"
+func (r *Request) RoutePattern() string {
+	if r.route == nil {
+		return ""
+	}
+	return r.route.pattern
+}
@@ -1,13 +1,20 @@
 package frodo
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/kn9ts/frodo/sessions"
 )
 
 // Router is a http.Handler which can be used to dispatch requests to different
@@ -15,6 +22,27 @@ import (
 type Router struct {
 	trees map[string]*node
 
+	// named routes, registered through Attributes{Name: "..."}
+	routes map[string]*route
+
+	// closed when the server starts shutting down, a new one every Run
+	shutdownMu    sync.Mutex
+	shuttingDown  chan struct{}
+	shutdownHooks []func(ctx context.Context)
+
+	// set while Run serves h2c, see Server.H2C
+	h2cUpgrades atomic.Pointer[connListener]
+
+	// set through Router.CORS
+	cors *cors
+
+	// set through Router.CSRF
+	csrf *csrf
+
+	// TrustedProxies parsed, see Router.TrustedProxies
+	proxiesOnce sync.Once
+	proxies     []func(netip.Addr) bool
+
 	// Enables automatic redirection if the current route can't be matched but a
 	// handler for the path with (without) the trailing slash exists.
 	// For example if /foo/ is requested but a route only exists for /foo, the
@@ -56,6 +84,40 @@ type Router struct {
 	// The handler can be used to keep your server from crashing because of
 	// unrecovered panics.
 	PanicHandler Handler
+
+	// Configurable http.Handler which is called when a request is over
+	// the limit of a RateLimit. If it is not set, http.Error with
+	// http.StatusTooManyRequests is used.
+	TooManyRequestsHandler Handler
+
+	// Logger receives the router's diagnostics, eg. a handler writing the headers twice.
+	// If it is not set, the standard logger is used.
+	Logger *log.Logger
+
+	// Storage is where uploaded files are moved to, when the call to
+	// UploadedFile.Move does not name one
+	Storage Storage
+
+	// UploadNaming picks the names uploaded files are stored under,
+	// KeepOriginalName is used if it is not set
+	UploadNaming NamingStrategy
+
+	// UploadCollision decides what happens when the name picked is taken,
+	// files are overwritten by default
+	UploadCollision CollisionPolicy
+
+	// Views renders the html/template pages handlers ask for through Request.Render
+	Views *Views
+
+	// Sessions hands out the sessions handlers reach through Request.Session
+	Sessions *sessions.Manager
+
+	// TrustedProxies are the reverse proxies, eg. nginx or a load balancer, whose
+	// Forwarded, X-Forwarded-For, X-Real-Ip, X-Forwarded-Proto and X-Forwarded-Host
+	// headers are believed, as IPs or CIDRs, eg. "10.0.0.0/8", or "loopback" and
+	// "private" for those ranges. Those headers are ignored when it is empty, as
+	// any client can send them. It must be set before the first request.
+	TrustedProxies []string
 }
 
 // Make sure the Router conforms with the http.Handler interface
@@ -127,13 +189,27 @@ func (r *Router) Handle(method, path string, handlers ...interface{}) {
 		panic("path must begin with '/' in path '" + path + "'")
 	}
 
+	// the route is kept alongside the handlers in the tree,
+	// and handed to the Request before the handlers are run
+	rt := &route{method: method, pattern: path}
+
 	// this is used to collect all Handlers
-	var middleware = make([]Middleware, len(handlers))
+	var middleware []Middleware
 
-	for pos, h := range handlers {
+	for _, h := range handlers {
 		// to recieve the typecasted Controller or Handler
 		var handle Middleware
 
+		// Attributes are not handlers, they describe the route
+		if attrs, isAttributes := h.(Attributes); isAttributes {
+			rt.name = attrs.Name
+			continue
+		}
+		if attrs, isAttributes := h.(*Attributes); isAttributes {
+			rt.name = attrs.Name
+			continue
+		}
+
 		// Check to see if a Handler was provided if not
 		v := reflect.ValueOf(h).Type()
 		fmt.Printf("==> Handler provided: %s\n", v)
@@ -143,7 +219,10 @@ func (r *Router) Handle(method, path string, handlers ...interface{}) {
 		// if not check the function if it suffices the Handle type pattern
 		// If it does -- func(http.ResponseWriter, *Request)
 		// then convert it to a Frodo.Handler type
-		if value, isHandler := h.(func(http.ResponseWriter, *Request)); isHandler && v.Kind().String() == "func" {
+		if value, isHandler := h.(Handler); isHandler {
+			// eg. the middleware frodo itself provides
+			handle = value
+		} else if value, isHandler := h.(func(http.ResponseWriter, *Request)); isHandler && v.Kind().String() == "func" {
 			// morph it to it's dynamic data type
 			handle = makeHandler(value)
 		} else {
@@ -157,7 +236,7 @@ func (r *Router) Handle(method, path string, handlers ...interface{}) {
 			}
 		}
 		// replace the Middleware with correct Handler
-		middleware[pos] = handle
+		middleware = append(middleware, handle)
 	}
 	fmt.Printf("%v and the no %d\n", middleware, len(middleware))
 
@@ -172,7 +251,14 @@ func (r *Router) Handle(method, path string, handlers ...interface{}) {
 	}
 
 	// store them to it's route node
-	root.addRoute(path, middleware)
+	root.addRoute(path, middleware, rt)
+
+	if rt.name != "" {
+		if r.routes == nil {
+			r.routes = make(map[string]*route)
+		}
+		r.routes[rt.name] = rt
+	}
 }
 
 // Handler is an adapter which allows the usage of an
@@ -195,7 +281,7 @@ func (r *Router) HandlerFunc(method, path string, handler http.HandlerFunc) {
 // For example if root is "/etc" and *filepath is "passwd", the local file
 // "/etc/passwd" would be served.
 // Internally a http.FileServer is used, therefore http.NotFound is used instead
-// of the Router's NotFound handler.
+// of the Router's NotFound handler, see Static for more control.
 // To use the operating system's file system implementation,
 // use http.Dir:
 //     router.ServeFiles("/src/*filepath", http.Dir("/var/www"))
@@ -212,6 +298,25 @@ func (r *Router) ServeFiles(path string, root http.FileSystem) {
 	})
 }
 
+// Mount hands every request under the prefix, whichever the method, to the
+// http.Handler given. The prefix is stripped from the path before the handler sees it.
+//
+//	router.Mount("/uploads", frodo.Tus(storage))
+func (r *Router) Mount(prefix string, handler http.Handler, middleware ...interface{}) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	stripped := http.StripPrefix(prefix, handler)
+
+	handlers := append(middleware[:len(middleware):len(middleware)], func(w http.ResponseWriter, req *Request) {
+		stripped.ServeHTTP(w, req.Request)
+	})
+	for _, method := range MethodsAllowed {
+		if prefix != "" {
+			r.Handle(method, prefix, handlers...)
+		}
+		r.Handle(method, prefix+"/*filepath", handlers...)
+	}
+}
+
 // NotFound can be used to define custom routes to handle NotFound routes
 func (r *Router) NotFound(handler Handler) {
 	r.NotFoundHandler = handler
@@ -228,6 +333,12 @@ func (r *Router) ServerError(handler Handler) {
 	r.PanicHandler = handler
 }
 
+// TooManyRequests can be used to define custom routes to handle
+// requests over the limit of a RateLimit
+func (r *Router) TooManyRequests(handler Handler) {
+	r.TooManyRequestsHandler = handler
+}
+
 // On404 is shortform for NotFound
 func (r *Router) On404(handler Handler) {
 	r.NotFound(handler)
@@ -238,6 +349,11 @@ func (r *Router) On405(handler Handler) {
 	r.MethodNotAllowed(handler)
 }
 
+// On429 is shortform for TooManyRequests
+func (r *Router) On429(handler Handler) {
+	r.TooManyRequests(handler)
+}
+
 // On500 is shortform for ServerError
 func (r *Router) On500(handler Handler) {
 	r.ServerError(handler)
@@ -248,7 +364,7 @@ func (r *Router) recover(w *ResponseWriter, req *Request) {
 		// if a custom panic handler has been defined
 		// run that instead
 		if r.PanicHandler != nil {
-			r.PanicHandler(w, req)
+			r.PanicHandler(w.handlerWriter(), req)
 			return
 		}
 
@@ -265,13 +381,19 @@ func (r *Router) recover(w *ResponseWriter, req *Request) {
 // the same path with an extra / without the trailing slash should be performed.
 func (r *Router) Lookup(method, path string) ([]Middleware, Params, bool) {
 	if root := r.trees[method]; root != nil {
-		return root.getValue(path)
+		handlers, _, ps, tsr := root.getValue(path)
+		return handlers, ps, tsr
 	}
 	return nil, nil, false
 }
 
 // ServeHTTP makes the router implement the http.Handler interface.
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// a request asking to switch to HTTP/2 is answered over it
+	if upgrades := r.h2cUpgrades.Load(); upgrades != nil && h2cUpgrade(w, req, upgrades) {
+		return
+	}
+
 	// 1st things 1st, wrap the response writter
 	// to add the extra functionality we want basically
 	// trace when a write happens
@@ -280,28 +402,58 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		timeStart:      time.Now(),
 		method:         req.Method,
 		route:          req.URL.Path,
+		logger:         r.Logger,
+		request:        req,
 	}
+	defer FrodoWritter.finish()
 
 	// Wrap the supplied http.Request
 	FrodoRequest := Request{
 		Request: req,
+		router:  r,
+		writer:  &FrodoWritter,
 		// files []*UploadFile
 	}
 
+	// an event stream must not outlive the request it was opened on
+	defer func() {
+		if FrodoRequest.stream != nil {
+			FrodoRequest.stream.Close()
+		}
+		// a changed session still has to be saved when nothing was written
+		if FrodoRequest.session != nil && !FrodoWritter.HeaderWritten() {
+			FrodoWritter.WriteHeader(http.StatusOK)
+		}
+	}()
+
 	// ---------- Handle 500: Internal Server Error -----------
 	// If a panic/error takes place while process,
 	// recover and run PanicHandle if defined
 	defer r.recover(&FrodoWritter, &FrodoRequest)
 
+	if r.cors != nil {
+		if r.cors.isPreflight(&FrodoRequest) && !r.hasRoute("OPTIONS", req.URL.Path) {
+			r.cors.preflight(FrodoWritter.handlerWriter(), &FrodoRequest)
+			return
+		}
+		r.cors.actual(&FrodoWritter, &FrodoRequest)
+	}
+
 	if root := r.trees[req.Method]; root != nil {
 		path := req.URL.Path
 
 		// get the Handle of the route path requested
-		handlers, ps, tsr := root.getValue(path)
+		handlers, rt, ps, tsr := root.getValue(path)
+		FrodoRequest.route = rt
 
 		// if []Middleware was found were found, run it!
 		noOfHandlers := len(handlers)
 		if noOfHandlers > 0 {
+			// the route is known by now, so it can be exempted
+			if r.csrf != nil && !r.csrf.check(FrodoWritter.handlerWriter(), &FrodoRequest) {
+				return
+			}
+
 			// if the 1st handler is defined, run it
 			FrodoRequest.Params = ps
 			FrodoRequest.RequestMiddleware = &RequestMiddleware{
@@ -334,7 +486,7 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 					req.URL.Path = path + "/"
 				}
 
-				http.Redirect(w, req, req.URL.String(), code)
+				http.Redirect(&FrodoWritter, req, req.URL.String(), code)
 				return
 			}
 
@@ -346,7 +498,7 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 				)
 				if found {
 					req.URL.Path = string(fixedPath)
-					http.Redirect(w, req, req.URL.String(), code)
+					http.Redirect(&FrodoWritter, req, req.URL.String(), code)
 					return
 				}
 			}
@@ -361,15 +513,15 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 				continue
 			}
 
-			handle, ps, _ := r.trees[method].getValue(req.URL.Path)
+			handle, _, ps, _ := r.trees[method].getValue(req.URL.Path)
 			if handle != nil {
 				if r.MethodNotAllowedHandler != nil {
 					FrodoRequest.Params = ps
-					r.MethodNotAllowedHandler(&FrodoWritter, &FrodoRequest)
+					r.MethodNotAllowedHandler(FrodoWritter.handlerWriter(), &FrodoRequest)
 					return
 				}
 				// if no MethodNotAllowedHandler found, just throw an error the old way
-				http.Error(w, http.StatusText(405), http.StatusMethodNotAllowed)
+				http.Error(&FrodoWritter, http.StatusText(405), http.StatusMethodNotAllowed)
 				return
 			}
 		}
@@ -377,14 +529,27 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Handle 404
+	r.notFound(FrodoWritter.handlerWriter(), &FrodoRequest)
+}
+
+// notFound answers with the NotFoundHandler if one is defined
+func (r *Router) notFound(w http.ResponseWriter, req *Request) {
 	if r.NotFoundHandler != nil {
-		r.NotFoundHandler(&FrodoWritter, &FrodoRequest)
+		r.NotFoundHandler(w, req)
 		return
 	}
 
 	// If there is not Handle for a 404 error use Go's w
 	http.Error(w, http.StatusText(404), http.StatusNotFound)
-	return
+}
+
+// logf writes to the logger given, falling back to the standard logger
+func logf(logger *log.Logger, format string, args ...interface{}) {
+	if logger == nil {
+		log.Printf(format, args...)
+		return
+	}
+	logger.Printf(format, args...)
 }
 
 // Serve deploys the application
@@ -392,12 +557,14 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 // The "Fourth Age" followed the defeat of Sauron and the destruction of his One Ring,
 // but did not officially begin until after the Bearers of the Three Rings left Middle-earth for Valinor,
 // the 'Uttermost West'
-func (r *Router) Serve() {
-	r.ServeOnPort(3102)
+func (r *Router) Serve() error {
+	return r.ServeOnPort(3102)
 }
 
-// ServeOnPort is to used if you plan change the port to serve the application on
-func (r *Router) ServeOnPort(portNumber interface{}) {
+// ServeOnPort is to used if you plan change the port to serve the application on.
+// Given a string it can also be any address Listen accepts, eg. "127.0.0.1:8080"
+// or "unix:/run/app.sock". It is Run, with the default Server config, see Run for more control.
+func (r *Router) ServeOnPort(portNumber interface{}) error {
 	var portNumberAsString string
 	// Converting an interface into the data type it should be
 	if pns, ok := portNumber.(int); ok {
@@ -410,17 +577,18 @@ func (r *Router) ServeOnPort(portNumber interface{}) {
 			}
 			portNumberAsString = pns
 		} else {
-			log.Fatal("[ERROR] PortNumber can only be a numeral string or integer")
-			return
+			return errors.New("frodo: PortNumber can only be a numeral string or integer")
 		}
 	}
 
-	err := http.ListenAndServe(":"+portNumberAsString, r)
+	// a bare port listens on all interfaces
+	addr := portNumberAsString
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+	err := r.Run(context.Background(), Server{Addr: addr})
 	if err != nil {
-		log.Fatalf("[ERROR] Server failed to initialise: %s", err)
-		return
+		logf(r.Logger, "[ERROR] Server failed: %s", err)
 	}
-
-	// If server successfully Launched
-	log.Printf("[LOG] Server deployed at: %s", portNumberAsString)
+	return err
 }
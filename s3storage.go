@@ -0,0 +1,498 @@
+package frodo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps files in a bucket of an S3 compatible object store,
+// eg. AWS S3, MinIO or Ceph. Requests are signed with AWS Signature Version 4.
+type S3Storage struct {
+	// Endpoint is the address of the store, eg. "https://s3.eu-west-1.amazonaws.com"
+	// or "http://localhost:9000"
+	Endpoint string
+
+	// Region the bucket lives in, defaults to "us-east-1"
+	Region string
+
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// PathStyle addresses the bucket as "endpoint/bucket/key" instead of
+	// "bucket.endpoint/key", most self hosted stores need it
+	PathStyle bool
+
+	// BaseURL is where the bucket is publicly served from, eg. a CDN.
+	// If it is not set, the object's address on the Endpoint is used.
+	BaseURL string
+
+	// Client sends the requests, http.DefaultClient is used if it is not set
+	Client *http.Client
+
+	// PartSize is the size of the parts a body of unknown length is uploaded in,
+	// DefaultS3PartSize if it is not set. S3 needs parts of at least 5MB.
+	PartSize int64
+}
+
+// DefaultS3PartSize is the size of the parts a body of unknown length is uploaded in,
+// the most an S3Storage keeps in memory for each upload
+const DefaultS3PartSize = 8 << 20
+
+// NewS3Storage returns an S3Storage for the bucket on the given endpoint
+func NewS3Storage(endpoint, region, bucket, accessKeyID, secretAccessKey string) *S3Storage {
+	return &S3Storage{
+		Endpoint:        endpoint,
+		Region:          region,
+		Bucket:          bucket,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+	}
+}
+
+// S3Error is returned when the object store answers with an error
+type S3Error struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("frodo: s3 request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// Put uploads the body as an object. A body that cannot tell its length, eg. a
+// Part being streamed, is uploaded in parts of PartSize with a multipart upload,
+// unless it all fits in the first. NoReplace sends If-None-Match: *, so the store
+// itself refuses to overwrite an object.
+func (s *S3Storage) Put(ctx context.Context, key string, body io.Reader, contentType string, opts ...PutOptions) (*StoredFile, error) {
+	key = cleanKey(key)
+	noReplace := putOptions(opts).NoReplace
+	size, body, err := readerSize(body)
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		part, err := readPart(body, make([]byte, s.partSize()))
+		if err != nil {
+			return nil, err
+		}
+		if len(part) == cap(part) {
+			return s.putMultipart(ctx, key, part, body, contentType, noReplace)
+		}
+		// all of it fits in one request
+		size, body = int64(len(part)), bytes.NewReader(part)
+	}
+
+	req, err := s.request(ctx, "PUT", key, nil, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if noReplace {
+		req.Header.Set("If-None-Match", "*")
+	}
+
+	res, err := s.do(req)
+	if err != nil {
+		return nil, conditionalError(err)
+	}
+	res.Body.Close()
+	return s.stored(key, size, contentType), nil
+}
+
+// putMultipart uploads the body part by part, starting with the part already read.
+// Should any step fail the upload is aborted, so the store drops the parts it got.
+func (s *S3Storage) putMultipart(ctx context.Context, key string, part []byte, body io.Reader, contentType string, noReplace bool) (*StoredFile, error) {
+	req, err := s.request(ctx, "POST", key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&created)
+	res.Body.Close()
+	if err != nil || created.UploadID == "" {
+		return nil, fmt.Errorf("frodo: s3 multipart upload of %q was not created: %v", key, err)
+	}
+
+	size, err := s.uploadParts(ctx, key, created.UploadID, part, body, noReplace)
+	if err != nil {
+		// the request may be what failed, aborting must still go through
+		abort, abortErr := s.request(context.WithoutCancel(ctx), "DELETE", key, url.Values{"uploadId": {created.UploadID}}, nil)
+		if abortErr == nil {
+			if res, abortErr := s.do(abort); abortErr == nil {
+				res.Body.Close()
+			}
+		}
+		return nil, err
+	}
+	return s.stored(key, size, contentType), nil
+}
+
+// s3CompleteUpload lists the parts of a multipart upload, in order
+type s3CompleteUpload struct {
+	XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+	Parts   []s3UploadedPart `xml:"Part"`
+}
+
+type s3UploadedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// uploadParts uploads the parts of a multipart upload, then completes it. The buffer
+// the first part was read into is reused for the rest, a part shorter than it is the last.
+func (s *S3Storage) uploadParts(ctx context.Context, key, uploadID string, part []byte, body io.Reader, noReplace bool) (int64, error) {
+	var complete s3CompleteUpload
+	var size int64
+	for number := 1; len(part) > 0; number++ {
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		req, err := s.request(ctx, "PUT", key, query, bytes.NewReader(part))
+		if err != nil {
+			return 0, err
+		}
+		res, err := s.do(req)
+		if err != nil {
+			return 0, err
+		}
+		res.Body.Close()
+		complete.Parts = append(complete.Parts, s3UploadedPart{number, res.Header.Get("ETag")})
+		size += int64(len(part))
+
+		if len(part) < cap(part) {
+			break
+		}
+		if part, err = readPart(body, part[:cap(part)]); err != nil {
+			return 0, err
+		}
+	}
+
+	list, err := xml.Marshal(complete)
+	if err != nil {
+		return 0, err
+	}
+	req, err := s.request(ctx, "POST", key, url.Values{"uploadId": {uploadID}}, bytes.NewReader(list))
+	if err != nil {
+		return 0, err
+	}
+	if noReplace {
+		req.Header.Set("If-None-Match", "*")
+	}
+	res, err := s.do(req)
+	if err != nil {
+		return 0, conditionalError(err)
+	}
+	defer res.Body.Close()
+
+	// completing can fail after the store has answered with 200 OK
+	raw, err := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if err != nil {
+		return 0, err
+	}
+	var failed struct {
+		XMLName xml.Name
+		Code    string
+	}
+	if xml.Unmarshal(raw, &failed) == nil && failed.XMLName.Local == "Error" {
+		if failed.Code == "PreconditionFailed" || failed.Code == "ConditionalRequestConflict" {
+			return 0, ErrFileExists
+		}
+		return 0, &S3Error{StatusCode: res.StatusCode, Body: string(raw)}
+	}
+	return size, nil
+}
+
+// partSize is the PartSize, or DefaultS3PartSize
+func (s *S3Storage) partSize() int64 {
+	if s.PartSize > 0 {
+		return s.PartSize
+	}
+	return DefaultS3PartSize
+}
+
+// stored describes an object just uploaded
+func (s *S3Storage) stored(key string, size int64, contentType string) *StoredFile {
+	return &StoredFile{
+		Key:         key,
+		Size:        size,
+		ContentType: contentType,
+		ModTime:     time.Now(),
+		URL:         s.URL(key),
+		Storage:     s,
+	}
+}
+
+// Get downloads the object, the body is streamed from the store
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, *StoredFile, error) {
+	key = cleanKey(key)
+	req, err := s.request(ctx, "GET", key, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := s.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.Body, s.describe(key, res), nil
+}
+
+// Delete removes the object
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, "DELETE", cleanKey(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Exists checks for the object with a HEAD request
+func (s *S3Storage) Exists(ctx context.Context, key string) (bool, error) {
+	req, err := s.request(ctx, "HEAD", cleanKey(key), nil, nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.do(req)
+	if err == ErrFileNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+	return true, nil
+}
+
+// URL returns where the object is served from
+func (s *S3Storage) URL(key string) string {
+	key = cleanKey(key)
+	if s.BaseURL != "" {
+		return joinURL(s.BaseURL, key)
+	}
+	return s.objectURL(key).String()
+}
+
+// objectURL is the object's address on the Endpoint
+func (s *S3Storage) objectURL(key string) *url.URL {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Host == "" {
+		u = &url.URL{Scheme: "https", Host: strings.TrimSuffix(s.Endpoint, "/")}
+	}
+
+	objectPath := "/" + key
+	if s.PathStyle {
+		objectPath = "/" + s.Bucket + objectPath
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + objectPath
+	u.RawPath = s3EscapePath(u.Path)
+	return u
+}
+
+// request builds a signed request for the object
+func (s *S3Storage) request(ctx context.Context, method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := s.objectURL(key)
+	u.RawQuery = s3CanonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, time.Now().UTC())
+	return req, nil
+}
+
+// do sends the request, turning error responses into errors
+func (s *S3Storage) do(req *http.Request) (*http.Response, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrFileNotFound
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 4<<10))
+	return nil, &S3Error{StatusCode: res.StatusCode, Body: string(msg)}
+}
+
+// conditionalError turns the store refusing a conditional write into ErrFileExists,
+// 412 when the object exists, 409 when another write to it is under way
+func conditionalError(err error) error {
+	var s3Err *S3Error
+	if errors.As(err, &s3Err) && (s3Err.StatusCode == http.StatusPreconditionFailed || s3Err.StatusCode == http.StatusConflict) {
+		return ErrFileExists
+	}
+	return err
+}
+
+// describe builds the StoredFile from the object's response headers
+func (s *S3Storage) describe(key string, res *http.Response) *StoredFile {
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	size, err := strconv.ParseInt(res.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		size = res.ContentLength
+	}
+	return &StoredFile{
+		Key:         key,
+		Size:        size,
+		ContentType: res.Header.Get("Content-Type"),
+		ModTime:     modTime,
+		URL:         s.URL(key),
+		Storage:     s,
+	}
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to the request.
+// The payload is left unsigned so that uploads can be streamed.
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:UNSIGNED-PAYLOAD\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3CanonicalQuery encodes the query the way SigV4 expects, sorted by name,
+// with spaces as %20 and names without a value followed by "="
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(name)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape URI encodes a query name or value, "/" included
+func s3Escape(s string) string {
+	return strings.ReplaceAll(s3EscapePath(s), "/", "%2F")
+}
+
+// s3EscapePath URI encodes every segment of the path the way SigV4 expects,
+// leaving only the unreserved characters as they are
+func s3EscapePath(p string) string {
+	var buf strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			buf.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&buf, "%%%02X", c)
+	}
+	return buf.String()
+}
+
+// readerSize works out how many bytes are left in the reader, -1 when it cannot tell
+func readerSize(body io.Reader) (int64, io.Reader, error) {
+	switch r := body.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len()), body, nil
+	case io.Seeker:
+		current, err := r.Seek(0, io.SeekCurrent)
+		if err == nil {
+			end, err := r.Seek(0, io.SeekEnd)
+			if err == nil {
+				if _, err := r.Seek(current, io.SeekStart); err == nil {
+					return end - current, body, nil
+				}
+			}
+		}
+	}
+	return -1, body, nil
+}
+
+// readPart fills the buffer from the reader, returning less only once the reader is done
+func readPart(r io.Reader, buf []byte) ([]byte, error) {
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return buf[:n], err
+}
@@ -0,0 +1,369 @@
+package frodo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testS3Key    = "AKIDEXAMPLE"
+	testS3Secret = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+// fakeS3 stands in for an S3 bucket, addressed path style. It checks every request's
+// SigV4 signature, keeps the objects in memory and supports multipart uploads.
+type fakeS3 struct {
+	t      *testing.T
+	bucket string
+
+	mu       sync.Mutex
+	objects  map[string]fakeObject
+	uploads  map[string]map[int][]byte
+	requests []string
+	rejected int
+	failPart int
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3Storage) {
+	fake := &fakeS3{
+		t:       t,
+		bucket:  "uploads",
+		objects: make(map[string]fakeObject),
+		uploads: make(map[string]map[int][]byte),
+	}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	storage := NewS3Storage(srv.URL, "eu-west-1", fake.bucket, testS3Key, testS3Secret)
+	storage.PathStyle = true
+	return fake, storage
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f.verify(r); err != nil {
+		f.t.Logf("%s %s: %v", r.Method, r.URL, err)
+		f.mu.Lock()
+		f.rejected++
+		f.mu.Unlock()
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if !ok {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.RawQuery)
+
+	switch {
+	case r.Method == "POST" && query.Has("uploads"):
+		id := fmt.Sprintf("upload-%d", len(f.requests))
+		f.uploads[id] = make(map[int][]byte)
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", f.bucket, key, id)
+
+	case r.Method == "PUT" && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchUpload</Code></Error>", http.StatusNotFound)
+			return
+		}
+		var number int
+		fmt.Sscan(query.Get("partNumber"), &number)
+		if number == f.failPart {
+			http.Error(w, "<Error><Code>InternalError</Code></Error>", http.StatusInternalServerError)
+			return
+		}
+		parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, number))
+
+	case r.Method == "POST" && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchUpload</Code></Error>", http.StatusNotFound)
+			return
+		}
+		var complete s3CompleteUpload
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			http.Error(w, "<Error><Code>MalformedXML</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		if _, taken := f.objects[key]; taken && r.Header.Get("If-None-Match") == "*" {
+			// S3 reports a failed completion with 200 OK
+			fmt.Fprint(w, "<Error><Code>PreconditionFailed</Code></Error>")
+			return
+		}
+		var data []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"etag-%d"`, i+1) {
+				http.Error(w, "<Error><Code>InvalidPart</Code></Error>", http.StatusBadRequest)
+				return
+			}
+			data = append(data, parts[part.PartNumber]...)
+		}
+		delete(f.uploads, query.Get("uploadId"))
+		f.objects[key] = fakeObject{data: data}
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+
+	case r.Method == "DELETE" && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == "PUT":
+		if _, taken := f.objects[key]; taken && r.Header.Get("If-None-Match") == "*" {
+			http.Error(w, "<Error><Code>PreconditionFailed</Code></Error>", http.StatusPreconditionFailed)
+			return
+		}
+		if int64(len(body)) != r.ContentLength {
+			http.Error(w, "<Error><Code>IncompleteBody</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = fakeObject{data: body, contentType: r.Header.Get("Content-Type")}
+
+	case r.Method == "GET" || r.Method == "HEAD":
+		object, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprint(len(object.data)))
+		w.Write(object.data)
+
+	case r.Method == "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "<Error><Code>MethodNotAllowed</Code></Error>", http.StatusMethodNotAllowed)
+	}
+}
+
+// verify recomputes the request's AWS Signature Version 4 the way S3 does
+func (f *fakeS3) verify(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ", ") {
+		name, value, _ := strings.Cut(field, "=")
+		fields[name] = value
+	}
+	credential := strings.Split(fields["Credential"], "/")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") || len(credential) != 5 || credential[0] != testS3Key {
+		return fmt.Errorf("malformed Authorization %q", auth)
+	}
+	day, region := credential[1], credential[2]
+	amzDate := r.Header.Get("X-Amz-Date")
+	if !strings.HasPrefix(amzDate, day) {
+		return fmt.Errorf("X-Amz-Date %q is not on %s", amzDate, day)
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	query := r.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, awsEscape(name)+"="+awsEscape(value))
+		}
+	}
+
+	canonical := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(pairs, "&"),
+		headers.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	hashed := sha256.Sum256([]byte(canonical))
+	scope := strings.Join(credential[1:], "/")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + testS3Secret)
+	for _, part := range []string{day, region, "s3", "aws4_request"} {
+		key = mac(key, part)
+	}
+	if want := hex.EncodeToString(mac(key, stringToSign)); !hmac.Equal([]byte(want), []byte(fields["Signature"])) {
+		return fmt.Errorf("signature mismatch for canonical request:\n%s", canonical)
+	}
+	return nil
+}
+
+func mac(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// onlyReader hides the Len and Seek methods of the reader it wraps
+type onlyReader struct {
+	io.Reader
+}
+
+func TestS3StoragePutGetExistsDelete(t *testing.T) {
+	fake, storage := newFakeS3(t)
+	ctx := context.Background()
+	key := "avatars/my cat+1.png"
+	defer func() {
+		if fake.rejected != 0 {
+			t.Fatalf("%d requests were not signed right", fake.rejected)
+		}
+	}()
+
+	stored, err := storage.Put(ctx, key, strings.NewReader("meow"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Key != key || stored.Size != 4 || stored.ContentType != "image/png" {
+		t.Fatalf("stored %+v", stored)
+	}
+	if _, ok := fake.objects[key]; !ok {
+		t.Fatalf("the object is not stored under %q: %v", key, fake.objects)
+	}
+
+	exists, err := storage.Exists(ctx, key)
+	if err != nil || !exists {
+		t.Fatalf("Exists = %v, %v", exists, err)
+	}
+
+	body, info, err := storage.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(body)
+	body.Close()
+	if string(data) != "meow" || info.ContentType != "image/png" || info.Size != 4 {
+		t.Fatalf("Get = %q, %+v", data, info)
+	}
+
+	if _, err := storage.Put(ctx, key, strings.NewReader("woof"), "image/png", PutOptions{NoReplace: true}); !errors.Is(err, ErrFileExists) {
+		t.Fatalf("a NoReplace Put onto a taken key returned %v", err)
+	}
+
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := storage.Exists(ctx, key); err != nil || exists {
+		t.Fatalf("Exists after Delete = %v, %v", exists, err)
+	}
+	if _, _, err := storage.Get(ctx, key); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("Get after Delete returned %v", err)
+	}
+}
+
+func TestS3StorageRejectsBadSignature(t *testing.T) {
+	fake, storage := newFakeS3(t)
+	storage.SecretAccessKey = "not the secret"
+
+	_, err := storage.Put(context.Background(), "a.txt", strings.NewReader("a"), "text/plain")
+	var s3Err *S3Error
+	if !errors.As(err, &s3Err) || s3Err.StatusCode != http.StatusForbidden {
+		t.Fatalf("Put with the wrong secret returned %v", err)
+	}
+	if fake.rejected != 1 || len(fake.objects) != 0 {
+		t.Fatalf("rejected %d requests, stored %v", fake.rejected, fake.objects)
+	}
+}
+
+func TestS3StorageStreamsUnknownLength(t *testing.T) {
+	fake, storage := newFakeS3(t)
+	storage.PartSize = 1024
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("0123456789"), 250)
+
+	stored, err := storage.Put(ctx, "big.bin", onlyReader{bytes.NewReader(data)}, "application/octet-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Size != int64(len(data)) || !bytes.Equal(fake.objects["big.bin"].data, data) {
+		t.Fatalf("stored %d bytes, the bucket holds %d", stored.Size, len(fake.objects["big.bin"].data))
+	}
+	want := []string{
+		"POST uploads=",
+		"PUT partNumber=1&uploadId=upload-1",
+		"PUT partNumber=2&uploadId=upload-1",
+		"PUT partNumber=3&uploadId=upload-1",
+		"POST uploadId=upload-1",
+	}
+	if strings.Join(fake.requests, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("requests %q, want %q", fake.requests, want)
+	}
+
+	// a body that fits in one part is sent in one request
+	fake.requests = nil
+	if _, err := storage.Put(ctx, "small.txt", onlyReader{strings.NewReader("tiny")}, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.requests) != 1 || string(fake.objects["small.txt"].data) != "tiny" {
+		t.Fatalf("requests %q", fake.requests)
+	}
+
+	// completing onto a taken key fails, though S3 answers 200 OK
+	_, err = storage.Put(ctx, "big.bin", onlyReader{bytes.NewReader(data)}, "", PutOptions{NoReplace: true})
+	if !errors.Is(err, ErrFileExists) {
+		t.Fatalf("a NoReplace multipart Put onto a taken key returned %v", err)
+	}
+	if fake.rejected != 0 {
+		t.Fatalf("%d requests were not signed right", fake.rejected)
+	}
+}
+
+func TestS3StorageAbortsFailedUpload(t *testing.T) {
+	fake, storage := newFakeS3(t)
+	storage.PartSize = 1024
+	fake.failPart = 2
+
+	data := bytes.Repeat([]byte("x"), 3000)
+	if _, err := storage.Put(context.Background(), "big.bin", onlyReader{bytes.NewReader(data)}, ""); err == nil {
+		t.Fatal("a Put whose part failed succeeded")
+	}
+	if len(fake.uploads) != 0 {
+		t.Fatalf("the failed upload was not aborted: %v", fake.uploads)
+	}
+	if _, ok := fake.objects["big.bin"]; ok {
+		t.Fatal("the failed upload was stored")
+	}
+}
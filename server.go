@@ -0,0 +1,306 @@
+package frodo
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// DefaultAddr is the address Run listens on when the Server does not name one,
+// see Serve for why port 3102
+const DefaultAddr = ":3102"
+
+// DefaultShutdownTimeout is how long in-flight requests are given to finish
+// when the server is shutting down
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Server configures the http.Server Run starts. Zero values leave Go's defaults,
+// which have no timeouts at all, so setting them is strongly advised.
+type Server struct {
+	// Addr is the address to listen on, see Listen for the forms it takes.
+	// DefaultAddr is used when neither Addr, Addrs nor Listeners are set.
+	Addr string
+
+	// Addrs are more addresses to listen on, all of them serve the application
+	// and are shut down together
+	Addrs []string
+
+	// Listeners are served along with the addresses, eg. those handed
+	// over by another process. They are closed on shutdown.
+	Listeners []net.Listener
+
+	// SocketMode sets the permissions of the Unix sockets listened on, eg. 0660
+	SocketMode os.FileMode
+
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+
+	// TLSConfig makes the server speak HTTPS, it must carry the certificates
+	TLSConfig *tls.Config
+
+	// ErrorLog receives the errors of the http.Server, eg. failed TLS handshakes.
+	// The Router's Logger is used if it is not set.
+	ErrorLog *log.Logger
+
+	// ShutdownTimeout caps how long in-flight requests are waited on once shutting
+	// down, DefaultShutdownTimeout if zero. Those still running are then cut off.
+	ShutdownTimeout time.Duration
+
+	// HTTPRedirectAddr, along with a TLSConfig, starts a plain HTTP server
+	// on this address redirecting every request over to HTTPS, eg. ":80"
+	HTTPRedirectAddr string
+
+	// H2C serves HTTP/2 without TLS, as well as HTTP/1.1, for clients that know
+	// the server speaks it and those asking to switch with "Upgrade: h2c".
+	// It is meant for traffic between services on a trusted network.
+	H2C bool
+
+	// Upgrader, when set, opens the addresses so they can be handed over
+	// to a new process, and shuts the server down once it did
+	Upgrader *Upgrader
+
+	// Ready is called once the server is listening, with the address it listens on
+	Ready func(addr net.Addr)
+}
+
+// httpServer builds the http.Server the config describes
+func (s *Server) httpServer(r *Router) *http.Server {
+	errorLog := s.ErrorLog
+	if errorLog == nil {
+		errorLog = r.Logger
+	}
+	var protocols *http.Protocols
+	if s.H2C {
+		protocols = new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	return &http.Server{
+		Handler:           r,
+		Protocols:         protocols,
+		ReadTimeout:       s.ReadTimeout,
+		ReadHeaderTimeout: s.ReadHeaderTimeout,
+		WriteTimeout:      s.WriteTimeout,
+		IdleTimeout:       s.IdleTimeout,
+		MaxHeaderBytes:    s.MaxHeaderBytes,
+		TLSConfig:         s.TLSConfig,
+		ErrorLog:          errorLog,
+	}
+}
+
+// Run serves the application until the context is cancelled, or the process
+// receives SIGINT or SIGTERM. It then stops accepting connections, gives the
+// in-flight requests until the ShutdownTimeout to finish, and runs the
+// OnShutdown hooks. Run returns nil after a clean shutdown.
+//
+//	err := app.Run(context.Background(), frodo.Server{
+//		Addr:              ":8080",
+//		ReadHeaderTimeout: 5 * time.Second,
+//		IdleTimeout:       2 * time.Minute,
+//	})
+func (r *Router) Run(ctx context.Context, config ...Server) error {
+	var cfg Server
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+
+	addrs := cfg.Addrs
+	if cfg.Addr != "" {
+		addrs = append([]string{cfg.Addr}, addrs...)
+	}
+	if len(addrs) == 0 && len(cfg.Listeners) == 0 {
+		addrs = []string{DefaultAddr}
+	}
+
+	listeners := append([]net.Listener{}, cfg.Listeners...)
+	for _, addr := range addrs {
+		var opened []net.Listener
+		var err error
+		if cfg.Upgrader != nil {
+			opened, err = cfg.Upgrader.Listen(addr, cfg.SocketMode)
+		} else {
+			opened, err = Listen(addr, cfg.SocketMode)
+		}
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, opened...)
+	}
+	return r.run(ctx, &cfg, listeners...)
+}
+
+// run serves on the listeners until it is time to shut down
+func (r *Router) run(ctx context.Context, cfg *Server, listeners ...net.Listener) error {
+	server := cfg.httpServer(r)
+	r.resetShuttingDown()
+
+	var redirect *http.Server
+	if cfg.TLSConfig != nil && cfg.HTTPRedirectAddr != "" && len(listeners) > 0 {
+		ln, err := net.Listen("tcp", cfg.HTTPRedirectAddr)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return err
+		}
+		_, port, _ := net.SplitHostPort(listeners[0].Addr().String())
+		redirect = &http.Server{
+			Handler:           redirectToHTTPS(port),
+			ReadHeaderTimeout: 10 * time.Second,
+			ErrorLog:          server.ErrorLog,
+		}
+		go redirect.Serve(ln)
+		logf(r.Logger, "[LOG] Redirecting HTTP at %s over to HTTPS", ln.Addr())
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// connections switching to HTTP/2 with "Upgrade: h2c" are
+	// taken over by the Router and served again through here
+	if cfg.H2C && cfg.TLSConfig == nil && len(listeners) > 0 {
+		upgrades := newConnListener(listeners[0].Addr())
+		r.h2cUpgrades.Store(upgrades)
+		defer r.h2cUpgrades.CompareAndSwap(upgrades, nil)
+		go server.Serve(upgrades)
+	}
+
+	// decided up front, serving the first listener sets up server.TLSConfig for HTTP/2
+	useTLS := cfg.TLSConfig != nil
+	failed := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			if useTLS {
+				failed <- server.ServeTLS(ln, "", "")
+				return
+			}
+			failed <- server.Serve(ln)
+		}(ln)
+
+		logf(r.Logger, "[LOG] Server deployed at: %s", ln.Addr())
+		if cfg.Ready != nil {
+			cfg.Ready(ln.Addr())
+		}
+	}
+
+	var upgraded <-chan struct{}
+	if cfg.Upgrader != nil {
+		if err := cfg.Upgrader.Ready(); err != nil {
+			logf(r.Logger, "[ERROR] Telling the previous process this one is ready: %s", err)
+		}
+		upgraded = cfg.Upgrader.Exit()
+	}
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case <-upgraded:
+	case serveErr = <-failed:
+	}
+	// a second signal kills the process as usual
+	stop()
+
+	timeout := cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	logf(r.Logger, "[LOG] Server shutting down, waiting up to %s for requests to finish", timeout)
+	if redirect != nil {
+		redirect.Shutdown(shutdownCtx)
+	}
+	err := r.shutdown(shutdownCtx, server)
+
+	if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+		return serveErr
+	}
+	return err
+}
+
+// shutdown drains the server and runs the OnShutdown hooks
+func (r *Router) shutdown(ctx context.Context, server *http.Server) error {
+	r.closeShuttingDown()
+
+	err := server.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		server.Close()
+		err = fmt.Errorf("frodo: requests still running after the shutdown timeout: %w", err)
+	}
+
+	r.shutdownMu.Lock()
+	hooks := r.shutdownHooks
+	r.shutdownMu.Unlock()
+	for _, hook := range hooks {
+		hook(ctx)
+	}
+
+	if err != nil {
+		logf(r.Logger, "[ERROR] %s", err)
+		return err
+	}
+	logf(r.Logger, "[LOG] Server shut down")
+	return nil
+}
+
+// OnShutdown registers a hook to run, in the order registered, once the server has
+// stopped and the in-flight requests finished, eg. to close the database.
+// The context given expires with the ShutdownTimeout.
+func (r *Router) OnShutdown(hook func(ctx context.Context)) {
+	r.shutdownMu.Lock()
+	defer r.shutdownMu.Unlock()
+	r.shutdownHooks = append(r.shutdownHooks, hook)
+}
+
+// ShuttingDown returns a channel that is closed when the server starts shutting down.
+// Long running handlers, eg. those streaming events, should wrap up when it is.
+func (r *Router) ShuttingDown() <-chan struct{} {
+	r.shutdownMu.Lock()
+	defer r.shutdownMu.Unlock()
+	if r.shuttingDown == nil {
+		r.shuttingDown = make(chan struct{})
+	}
+	return r.shuttingDown
+}
+
+// closeShuttingDown closes the ShuttingDown channel, once
+func (r *Router) closeShuttingDown() {
+	r.shutdownMu.Lock()
+	defer r.shutdownMu.Unlock()
+	if r.shuttingDown == nil {
+		r.shuttingDown = make(chan struct{})
+	}
+	select {
+	case <-r.shuttingDown:
+	default:
+		close(r.shuttingDown)
+	}
+}
+
+// resetShuttingDown opens a new ShuttingDown channel when the previous run closed
+// it, so the Router can be served again
+func (r *Router) resetShuttingDown() {
+	r.shutdownMu.Lock()
+	defer r.shutdownMu.Unlock()
+	select {
+	case <-r.shuttingDown:
+		r.shuttingDown = make(chan struct{})
+	default:
+	}
+}
@@ -0,0 +1,52 @@
+package frodo
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestShuttingDownEveryRun(t *testing.T) {
+	app := New()
+	first := app.ShuttingDown()
+
+	for run := 1; run <= 2; run++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		ready := make(chan net.Addr, 1)
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- app.Run(ctx, Server{Addr: "127.0.0.1:0", Ready: func(addr net.Addr) { ready <- addr }})
+		}()
+		select {
+		case <-ready:
+		case err := <-stopped:
+			cancel()
+			t.Fatal(err)
+		}
+
+		shuttingDown := app.ShuttingDown()
+		select {
+		case <-shuttingDown:
+			t.Fatalf("run %d started shutting down", run)
+		default:
+		}
+
+		cancel()
+		if err := <-stopped; err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		select {
+		case <-shuttingDown:
+		case <-time.After(time.Second):
+			t.Fatalf("run %d did not close ShuttingDown", run)
+		}
+	}
+
+	// the channel handed out before the first run was the one it closed
+	select {
+	case <-first:
+	default:
+		t.Error("the first run did not close the channel handed out before it")
+	}
+}
@@ -0,0 +1,61 @@
+package frodo
+
+import (
+	"net/http"
+
+	"github.com/kn9ts/frodo/sessions"
+)
+
+// Session returns the client's session, kept by the Router's Sessions. It is only
+// read from the store once used, and saved, the cookie along with it, just before
+// the headers are written when it has changed. Changes made once the headers are
+// out are lost.
+//
+//	app.Sessions = sessions.New(sessions.NewCookieStore(secret))
+//
+//	app.Get("/", func(w http.ResponseWriter, r *frodo.Request) {
+//		visits, _ := r.Session().Get("visits").(int)
+//		r.Session().Set("visits", visits+1)
+//	})
+func (r *Request) Session() *sessions.Session {
+	if r.session != nil {
+		return r.session
+	}
+	if r.router == nil || r.router.Sessions == nil {
+		panic("Error: no sessions.Manager has been set on the Router, see Router.Sessions")
+	}
+
+	r.session = r.router.Sessions.Session(r.writer, r.Request)
+	r.writer.BeforeWriteHeader(func(w *ResponseWriter, code int) {
+		if err := r.session.Save(); err != nil {
+			logf(w.logger, "[ERROR] Saving the session: %s", err)
+		}
+	})
+	return r.session
+}
+
+// CSRFSessionStore keeps the CSRF token in the client's session, the synchronizer
+// token pattern with the Router's Sessions doing the storing
+type CSRFSessionStore struct {
+	// Key is the key the token is stored under, "_csrf" by default
+	Key string
+}
+
+func (s CSRFSessionStore) key() string {
+	if s.Key == "" {
+		return CSRFFieldName
+	}
+	return s.Key
+}
+
+// Load returns the token in the session
+func (s CSRFSessionStore) Load(r *Request) (string, error) {
+	token, _ := r.Session().Get(s.key()).(string)
+	return token, nil
+}
+
+// Save puts the token in the session
+func (s CSRFSessionStore) Save(w http.ResponseWriter, r *Request, token string) error {
+	r.Session().Set(s.key(), token)
+	return nil
+}
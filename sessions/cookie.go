@@ -0,0 +1,114 @@
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"time"
+)
+
+// MaxCookieSize is the largest cookie value a CookieStore writes, browsers
+// drop cookies over 4096 bytes, name and attributes included
+const MaxCookieSize = 3800
+
+// ErrCookieTooLarge is returned when the values of a session do not fit in the cookie
+var ErrCookieTooLarge = errors.New("sessions: the session is too large for a cookie")
+
+// CookieStore keeps the whole session in the cookie, encrypted and signed with
+// AES-GCM, so the client can neither read nor change it. Nothing is kept on the
+// server, which also means a session cannot be revoked before it expires:
+// Destroy only asks the browser to drop the cookie.
+type CookieStore struct {
+	aeads []cipher.AEAD
+}
+
+// cookiePayload is what is encrypted into the cookie
+type cookiePayload struct {
+	ID      string
+	Values  map[string]interface{}
+	Expires time.Time
+}
+
+// NewCookieStore returns a store encrypting the cookies with the first secret given,
+// and decrypting them with any of them, so secrets can be rotated. Secrets should
+// be at least 32 random bytes.
+func NewCookieStore(secrets ...[]byte) *CookieStore {
+	if len(secrets) == 0 {
+		panic("Error: a CookieStore needs at least one secret")
+	}
+	s := &CookieStore{}
+	for _, secret := range secrets {
+		key, err := hkdf.Key(sha256.New, secret, nil, "frodo sessions cookie", 32)
+		if err != nil {
+			panic("Error: deriving the CookieStore key: " + err.Error())
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			panic("Error: " + err.Error())
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			panic("Error: " + err.Error())
+		}
+		s.aeads = append(s.aeads, aead)
+	}
+	return s
+}
+
+// Load decrypts the session from the cookie's value
+func (s *CookieStore) Load(ctx context.Context, value string) (string, map[string]interface{}, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return "", nil, ErrNotFound
+	}
+	for _, aead := range s.aeads {
+		if len(sealed) < aead.NonceSize() {
+			break
+		}
+		plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+		if err != nil {
+			continue
+		}
+		var payload cookiePayload
+		if err := gob.NewDecoder(bytes.NewReader(plain)).Decode(&payload); err != nil {
+			return "", nil, err
+		}
+		if time.Now().After(payload.Expires) {
+			return "", nil, ErrNotFound
+		}
+		return payload.ID, payload.Values, nil
+	}
+	return "", nil, ErrNotFound
+}
+
+// Save encrypts the session into the cookie's value
+func (s *CookieStore) Save(ctx context.Context, id string, values map[string]interface{}, maxAge time.Duration) (string, error) {
+	var plain bytes.Buffer
+	payload := cookiePayload{ID: id, Values: values, Expires: time.Now().Add(maxAge)}
+	if err := gob.NewEncoder(&plain).Encode(&payload); err != nil {
+		return "", err
+	}
+
+	aead := s.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+plain.Len()+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	value := base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain.Bytes(), nil))
+	if len(value) > MaxCookieSize {
+		return "", ErrCookieTooLarge
+	}
+	return value, nil
+}
+
+// Delete does nothing, the session lives in the cookie only
+func (s *CookieStore) Delete(ctx context.Context, id string) error {
+	return nil
+}
@@ -0,0 +1,136 @@
+package sessions
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileSuffix ends the name of every session file, so Cleanup leaves other files alone
+const fileSuffix = ".session"
+
+// FileStore keeps every session in a file of its own, named after its id,
+// in a directory. The sessions survive restarts, and are shared by the
+// instances the directory is shared with.
+type FileStore struct {
+	dir string
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// fileSession is what is encoded into a session's file
+type fileSession struct {
+	Values  map[string]interface{}
+	Expires time.Time
+}
+
+// NewFileStore returns a store keeping the sessions in dir, which is created if need be
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+fileSuffix)
+}
+
+// Load reads the session's file
+func (s *FileStore) Load(ctx context.Context, value string) (string, map[string]interface{}, error) {
+	// the value names the file, it must not lead anywhere else
+	if !validID(value) {
+		return "", nil, ErrNotFound
+	}
+	f, err := os.Open(s.path(value))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil, ErrNotFound
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	var session fileSession
+	if err := gob.NewDecoder(f).Decode(&session); err != nil {
+		return "", nil, err
+	}
+	if time.Now().After(session.Expires) {
+		os.Remove(s.path(value))
+		return "", nil, ErrNotFound
+	}
+	return value, session.Values, nil
+}
+
+// Save writes the session's file, replacing it at once so a concurrent
+// Load never reads half of it. Expired sessions are removed every so often.
+func (s *FileStore) Save(ctx context.Context, id string, values map[string]interface{}, maxAge time.Duration) (string, error) {
+	if !validID(id) {
+		return "", errors.New("sessions: invalid session id")
+	}
+
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	session := fileSession{Values: values, Expires: time.Now().Add(maxAge)}
+	err = gob.NewEncoder(f).Encode(&session)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path(id))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	s.mu.Lock()
+	sweep := time.Since(s.lastSweep) > 10*time.Minute
+	if sweep {
+		s.lastSweep = time.Now()
+	}
+	s.mu.Unlock()
+	if sweep {
+		go s.Cleanup()
+	}
+	return id, nil
+}
+
+// Delete removes the session's file
+func (s *FileStore) Delete(ctx context.Context, id string) error {
+	if !validID(id) {
+		return nil
+	}
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Cleanup removes the files of the expired sessions
+func (s *FileStore) Cleanup() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), fileSuffix)
+		if !ok || !validID(id) {
+			continue
+		}
+		if _, _, err := s.Load(context.Background(), id); err != nil && !errors.Is(err, ErrNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
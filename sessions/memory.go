@@ -0,0 +1,87 @@
+package sessions
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the sessions in memory, they are lost on restart and not
+// shared between instances. Expired sessions are evicted as others are saved.
+// The zero value is ready to use.
+type MemoryStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memorySession
+	lastSweep time.Time
+}
+
+type memorySession struct {
+	values  map[string]interface{}
+	expires time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]memorySession)}
+}
+
+// Load returns a copy of the session's values
+func (s *MemoryStore) Load(ctx context.Context, value string) (string, map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[value]
+	if !ok || time.Now().After(session.expires) {
+		return "", nil, ErrNotFound
+	}
+	return value, copyValues(session.values), nil
+}
+
+// Save keeps a copy of the session's values, evicting those expired every so often
+func (s *MemoryStore) Save(ctx context.Context, id string, values map[string]interface{}, maxAge time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sessions == nil {
+		s.sessions = make(map[string]memorySession)
+	}
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.evict(now)
+	}
+	s.sessions[id] = memorySession{values: copyValues(values), expires: now.Add(maxAge)}
+	return id, nil
+}
+
+// Delete forgets the session
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// Len returns the number of sessions kept, expired ones not yet evicted included
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// evict drops the expired sessions, the lock must be held
+func (s *MemoryStore) evict(now time.Time) {
+	for id, session := range s.sessions {
+		if now.After(session.expires) {
+			delete(s.sessions, id)
+		}
+	}
+	s.lastSweep = now
+}
+
+// copyValues copies the map so the session can change it without the store seeing it
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,312 @@
+// Package sessions remembers a client between requests. A Manager hands out
+// the Session of each request, which a Store keeps either in the cookie itself,
+// encrypted, or on the server under a random id.
+//
+//	app.Sessions = sessions.New(sessions.NewCookieStore(secret))
+//
+//	app.Post("/login", func(w http.ResponseWriter, r *frodo.Request) {
+//		session := r.Session()
+//		session.Regenerate()
+//		session.Set("user", user.ID)
+//	})
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// DefaultName is the name of the session cookie when the Options do not give one
+const DefaultName = "session"
+
+// DefaultMaxAge is how long a session lasts since it was last saved
+const DefaultMaxAge = 24 * time.Hour
+
+// ErrNotFound is returned by a Store for a session it does not know, or that expired
+var ErrNotFound = errors.New("sessions: session not found")
+
+// idSize is the number of random bytes in a session id
+const idSize = 32
+
+// Store keeps the values of sessions
+type Store interface {
+	// Load returns the id and values of the session the cookie's value refers to,
+	// ErrNotFound when there is none or it expired
+	Load(ctx context.Context, value string) (id string, values map[string]interface{}, err error)
+
+	// Save keeps the values of the session for maxAge, returning the value
+	// of the cookie to refer to them by
+	Save(ctx context.Context, id string, values map[string]interface{}, maxAge time.Duration) (value string, err error)
+
+	// Delete forgets the session
+	Delete(ctx context.Context, id string) error
+}
+
+// Register records a type stored in sessions, as gob.Register does, so it can be encoded.
+// Strings, numbers, booleans and slices of them need not be registered.
+func Register(value interface{}) {
+	gob.Register(value)
+}
+
+// Options configures the session cookie. The defaults are the safe ones, the cookie
+// is sent over HTTPS only, kept from scripts and from cross-site requests.
+type Options struct {
+	// Name is the name of the cookie, DefaultName if empty
+	Name string
+
+	// Path and Domain scope the cookie, Path is "/" by default
+	Path   string
+	Domain string
+
+	// MaxAge is how long a session lasts since it was last saved, DefaultMaxAge if zero
+	MaxAge time.Duration
+
+	// BrowserSession drops the cookie when the browser is closed, the session
+	// still expires after MaxAge
+	BrowserSession bool
+
+	// Insecure lets the cookie be sent over plain HTTP, eg. during development
+	Insecure bool
+
+	// SameSite is http.SameSiteLaxMode by default
+	SameSite http.SameSite
+}
+
+// Manager hands out the sessions kept in its Store
+type Manager struct {
+	store Store
+	opts  Options
+}
+
+// New returns a Manager keeping the sessions in the store given
+func New(store Store, opts ...Options) *Manager {
+	var opt Options
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.Name == "" {
+		opt.Name = DefaultName
+	}
+	if opt.Path == "" {
+		opt.Path = "/"
+	}
+	if opt.MaxAge <= 0 {
+		opt.MaxAge = DefaultMaxAge
+	}
+	if opt.SameSite == 0 {
+		opt.SameSite = http.SameSiteLaxMode
+	}
+	return &Manager{store: store, opts: opt}
+}
+
+// Session returns the session of the request, it is loaded when first used.
+// Save must be called before the response's headers are written.
+func (m *Manager) Session(w http.ResponseWriter, r *http.Request) *Session {
+	return &Session{manager: m, w: w, r: r}
+}
+
+// cookie returns the session cookie with the value given
+func (m *Manager) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.opts.Name,
+		Value:    value,
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		MaxAge:   maxAge,
+		Secure:   !m.opts.Insecure,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	}
+}
+
+// Session holds the values remembered for a client
+type Session struct {
+	manager *Manager
+	w       http.ResponseWriter
+	r       *http.Request
+
+	id        string
+	values    map[string]interface{}
+	loaded    bool
+	modified  bool
+	destroyed bool
+	stale     []string
+	err       error
+}
+
+// load reads the session from the store the first time it is used
+func (s *Session) load() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+
+	if cookie, err := s.r.Cookie(s.manager.opts.Name); err == nil {
+		id, values, err := s.manager.store.Load(s.r.Context(), cookie.Value)
+		if err == nil {
+			s.id, s.values = id, values
+			if s.values == nil {
+				s.values = make(map[string]interface{})
+			}
+			return
+		}
+		if !errors.Is(err, ErrNotFound) {
+			s.err = err
+		}
+	}
+	s.values = make(map[string]interface{})
+}
+
+// ID returns the id of the session, empty until it is saved for the first time
+func (s *Session) ID() string {
+	s.load()
+	return s.id
+}
+
+// IsNew checks if the client had no session yet
+func (s *Session) IsNew() bool {
+	s.load()
+	return s.id == ""
+}
+
+// Get returns the value stored under the key, nil if there is none
+func (s *Session) Get(key string) interface{} {
+	s.load()
+	return s.values[key]
+}
+
+// Has checks if a value is stored under the key
+func (s *Session) Has(key string) bool {
+	s.load()
+	_, ok := s.values[key]
+	return ok
+}
+
+// Set stores the value under the key
+func (s *Session) Set(key string, value interface{}) {
+	s.load()
+	s.destroyed = false
+	s.values[key] = value
+	s.modified = true
+}
+
+// Delete removes the value stored under the key
+func (s *Session) Delete(key string) {
+	s.load()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.modified = true
+	}
+}
+
+// Regenerate moves the values over to a new id, forgetting the previous one.
+// It should be called whenever the privileges change, eg. on login, so an id
+// planted by an attacker is of no use to them.
+func (s *Session) Regenerate() error {
+	s.load()
+	if s.id != "" {
+		s.stale = append(s.stale, s.id)
+	}
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	s.id = id
+	s.destroyed = false
+	s.modified = true
+	return nil
+}
+
+// Destroy forgets the session and all its values, eg. on logout.
+// Values set afterwards start a new session.
+func (s *Session) Destroy() error {
+	s.load()
+	var err error
+	if s.id != "" {
+		err = s.manager.store.Delete(s.r.Context(), s.id)
+	}
+	s.id = ""
+	s.values = make(map[string]interface{})
+	s.destroyed = true
+	s.modified = false
+	return err
+}
+
+// Modified checks if the session has changed and will be saved
+func (s *Session) Modified() bool {
+	return s.modified || s.destroyed
+}
+
+// Err returns the error the store failed to load the session with, the session
+// is then empty
+func (s *Session) Err() error {
+	return s.err
+}
+
+// Save stores the session and sends the cookie, when it has changed.
+// It is called for the frodo.Request's Session before the headers are written.
+func (s *Session) Save() error {
+	ctx := s.r.Context()
+	var errs []error
+	for _, id := range s.stale {
+		if err := s.manager.store.Delete(ctx, id); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.stale = nil
+
+	if s.destroyed {
+		http.SetCookie(s.w, s.manager.cookie("", -1))
+		s.destroyed = false
+		return errors.Join(errs...)
+	}
+	if !s.modified {
+		return errors.Join(errs...)
+	}
+
+	if s.id == "" {
+		id, err := newID()
+		if err != nil {
+			return err
+		}
+		s.id = id
+	}
+	value, err := s.manager.store.Save(ctx, s.id, s.values, s.manager.opts.MaxAge)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	maxAge := int(s.manager.opts.MaxAge.Seconds())
+	if s.manager.opts.BrowserSession {
+		maxAge = 0
+	}
+	http.SetCookie(s.w, s.manager.cookie(value, maxAge))
+	s.modified = false
+	return errors.Join(errs...)
+}
+
+// newID returns a new random session id
+func newID() (string, error) {
+	b := make([]byte, idSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// validID checks the value could be an id newID returned, before it is used to look a session up
+func validID(id string) bool {
+	if len(id) != base64.RawURLEncoding.EncodedLen(idSize) {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
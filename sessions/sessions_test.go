@@ -0,0 +1,184 @@
+package sessions
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// saveCookie saves a session with the values through the store, returning the cookie's value
+func saveCookie(t *testing.T, store Store, values map[string]interface{}, maxAge time.Duration) string {
+	t.Helper()
+	value, err := store.Save(context.Background(), "id", values, maxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestCookieStoreTampering(t *testing.T) {
+	store := NewCookieStore([]byte("a secret of at least 32 random bytes"))
+	value := saveCookie(t, store, map[string]interface{}{"user": 42}, time.Hour)
+
+	id, values, err := store.Load(context.Background(), value)
+	if err != nil || id != "id" || values["user"] != 42 {
+		t.Fatalf("loaded %q %v, %v", id, values, err)
+	}
+
+	sealed, _ := base64.RawURLEncoding.DecodeString(value)
+	flip := func(i int) string {
+		tampered := append([]byte(nil), sealed...)
+		tampered[i] ^= 1
+		return base64.RawURLEncoding.EncodeToString(tampered)
+	}
+	tests := []struct {
+		name, value string
+	}{
+		{"nonce changed", flip(0)},
+		{"ciphertext changed", flip(len(sealed) / 2)},
+		{"tag changed", flip(len(sealed) - 1)},
+		{"truncated", value[:len(value)-4]},
+		{"too short for a nonce", value[:8]},
+		{"not base64", value + "!"},
+		{"empty", ""},
+		{"sealed by another secret", saveCookie(t, NewCookieStore([]byte("another secret")), map[string]interface{}{"user": 1}, time.Hour)},
+	}
+	for _, test := range tests {
+		if _, values, err := store.Load(context.Background(), test.value); !errors.Is(err, ErrNotFound) || values != nil {
+			t.Errorf("%s: loaded %v, %v", test.name, values, err)
+		}
+	}
+}
+
+func TestCookieStoreKeyRotation(t *testing.T) {
+	oldSecret, newSecret := []byte("the secret used so far"), []byte("the secret used from now on")
+	before := NewCookieStore(oldSecret)
+	after := NewCookieStore(newSecret, oldSecret)
+	value := saveCookie(t, before, map[string]interface{}{"user": 42}, time.Hour)
+
+	// cookies sealed with the old secret are still read
+	if _, values, err := after.Load(context.Background(), value); err != nil || values["user"] != 42 {
+		t.Fatalf("after the rotation loaded %v, %v", values, err)
+	}
+
+	// and sealed again with the new one
+	rotated := saveCookie(t, after, map[string]interface{}{"user": 42}, time.Hour)
+	if _, _, err := NewCookieStore(newSecret).Load(context.Background(), rotated); err != nil {
+		t.Errorf("the new secret alone cannot read the cookie saved after the rotation: %v", err)
+	}
+	if _, _, err := before.Load(context.Background(), rotated); !errors.Is(err, ErrNotFound) {
+		t.Errorf("the old secret alone read the cookie saved after the rotation: %v", err)
+	}
+
+	// once the old secret is dropped its cookies are no longer read
+	if _, _, err := NewCookieStore(newSecret).Load(context.Background(), value); !errors.Is(err, ErrNotFound) {
+		t.Errorf("a cookie sealed with a dropped secret loaded: %v", err)
+	}
+}
+
+func TestCookieStoreTooLarge(t *testing.T) {
+	store := NewCookieStore([]byte("secret"))
+	_, err := store.Save(context.Background(), "id", map[string]interface{}{"big": strings.Repeat("x", MaxCookieSize)}, time.Hour)
+	if !errors.Is(err, ErrCookieTooLarge) {
+		t.Errorf("saving a large session returned %v", err)
+	}
+}
+
+func TestStoresExpiry(t *testing.T) {
+	files, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := []struct {
+		name  string
+		store Store
+	}{
+		{"cookie", NewCookieStore([]byte("secret"))},
+		{"memory", NewMemoryStore()},
+		{"zero memory", &MemoryStore{}},
+		{"file", files},
+	}
+	for _, test := range stores {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			id, _ := newID()
+			lasting, err := test.store.Save(ctx, id, map[string]interface{}{"user": 42}, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, values, err := test.store.Load(ctx, lasting); err != nil || values["user"] != 42 {
+				t.Fatalf("loaded %v, %v", values, err)
+			}
+
+			short, _ := newID()
+			expiring, err := test.store.Save(ctx, short, map[string]interface{}{"user": 7}, 50*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			if _, values, err := test.store.Load(ctx, expiring); !errors.Is(err, ErrNotFound) {
+				t.Errorf("an expired session loaded %v, %v", values, err)
+			}
+			if _, _, err := test.store.Load(ctx, lasting); err != nil {
+				t.Errorf("the lasting session expired along: %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreEviction(t *testing.T) {
+	var store MemoryStore
+	ctx := context.Background()
+	store.Save(ctx, "expired", nil, -time.Second)
+	store.Save(ctx, "lasting", nil, time.Hour)
+	if store.Len() != 2 {
+		t.Fatalf("kept %d sessions", store.Len())
+	}
+
+	// the next save after a minute sweeps the expired ones
+	store.lastSweep = time.Now().Add(-2 * time.Minute)
+	store.Save(ctx, "new", nil, time.Hour)
+	if _, ok := store.sessions["expired"]; ok || store.Len() != 2 {
+		t.Errorf("after the sweep kept %d sessions", store.Len())
+	}
+
+	store.Delete(ctx, "lasting")
+	if _, _, err := store.Load(ctx, "lasting"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("a deleted session loaded: %v", err)
+	}
+}
+
+func TestManagerRejectsTamperedCookie(t *testing.T) {
+	manager := New(NewCookieStore([]byte("secret")))
+
+	rec := httptest.NewRecorder()
+	session := manager.Session(rec, httptest.NewRequest("GET", "/", nil))
+	session.Set("user", 42)
+	if err := session.Save(); err != nil {
+		t.Fatal(err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("saved the cookies %v", cookies)
+	}
+
+	tampered := *cookies[0]
+	tampered.Value = "A" + tampered.Value[1:]
+	if tampered.Value == cookies[0].Value {
+		tampered.Value = "B" + tampered.Value[1:]
+	}
+	for _, cookie := range []*http.Cookie{cookies[0], &tampered} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(cookie)
+		session := manager.Session(httptest.NewRecorder(), req)
+		wantNew := cookie == &tampered
+		if session.IsNew() != wantNew || (session.Get("user") == 42) == wantNew {
+			t.Errorf("the cookie %q gave a new session %v with the user %v", cookie.Value, session.IsNew(), session.Get("user"))
+		}
+	}
+}
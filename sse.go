@@ -0,0 +1,219 @@
+package frodo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSSEHeartbeat is how often an idle event stream sends a comment
+// to keep proxies and the browser from timing the connection out
+const DefaultSSEHeartbeat = 15 * time.Second
+
+// ErrStreamClosed is returned when sending on an event stream
+// whose client has gone away or that has been closed
+var ErrStreamClosed = errors.New("frodo: event stream closed")
+
+// EventStream is a Server-Sent Events stream back to the client,
+// opened by Request.SSE
+type EventStream struct {
+	// LastEventID is the id of the last event the client received,
+	// sent by browsers when they reconnect so the stream can be resumed
+	LastEventID string
+
+	w         *ResponseWriter
+	mu        sync.Mutex
+	heartbeat chan time.Duration
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// SSE turns the response into a text/event-stream. The stream is closed
+// when the client disconnects, ie. the request's context is cancelled.
+//
+//	stream, err := r.SSE()
+//	if err != nil {
+//		return
+//	}
+//	for {
+//		select {
+//		case <-stream.Done():
+//			return
+//		case msg := <-messages:
+//			stream.Send("message", msg.ID, msg)
+//		}
+//	}
+func (r *Request) SSE() (*EventStream, error) {
+	if r.writer == nil {
+		return nil, errors.New("frodo: SSE needs a request served by the Router")
+	}
+	if r.writer.HeaderWritten() {
+		return nil, errors.New("frodo: SSE cannot start, headers were already written")
+	}
+
+	// streams outlive any write timeout the server has
+	http.NewResponseController(r.writer).SetWriteDeadline(time.Time{})
+
+	header := r.writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	if r.ProtoMajor == 1 {
+		header.Set("Connection", "keep-alive")
+	}
+	header.Set("X-Accel-Buffering", "no")
+	if err := r.writer.FlushError(); err != nil {
+		return nil, fmt.Errorf("frodo: SSE needs a ResponseWriter that can flush: %w", err)
+	}
+
+	stream := &EventStream{
+		LastEventID: r.Header.Get("Last-Event-ID"),
+		w:           r.writer,
+		heartbeat:   make(chan time.Duration),
+		done:        make(chan struct{}),
+	}
+	if stream.LastEventID == "" {
+		// the polyfills for older browsers pass it along in the query
+		stream.LastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	r.stream = stream
+	var shuttingDown <-chan struct{}
+	if r.router != nil {
+		shuttingDown = r.router.ShuttingDown()
+	}
+	go stream.keepAlive(r.Context().Done(), shuttingDown)
+	return stream, nil
+}
+
+// Send writes an event out to the client, event and id may be empty.
+// Strings and bytes are sent as they are, anything else is encoded as JSON.
+func (s *EventStream) Send(event, id string, data interface{}) error {
+	var payload string
+	switch value := data.(type) {
+	case string:
+		payload = value
+	case []byte:
+		payload = string(value)
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		payload = string(encoded)
+	}
+
+	var msg strings.Builder
+	if id != "" {
+		fmt.Fprintf(&msg, "id: %s\n", singleLine(id))
+	}
+	if event != "" {
+		fmt.Fprintf(&msg, "event: %s\n", singleLine(event))
+	}
+	// CRLF, CR and LF all end a line in the stream
+	payload = strings.ReplaceAll(payload, "\r\n", "\n")
+	payload = strings.ReplaceAll(payload, "\r", "\n")
+	for _, line := range strings.Split(payload, "\n") {
+		fmt.Fprintf(&msg, "data: %s\n", line)
+	}
+	msg.WriteString("\n")
+	return s.write(msg.String())
+}
+
+// Comment writes a comment line, ignored by the browser
+func (s *EventStream) Comment(text string) error {
+	return s.write(": " + singleLine(text) + "\n\n")
+}
+
+// Retry tells the browser how long to wait before reconnecting
+func (s *EventStream) Retry(delay time.Duration) error {
+	return s.write(fmt.Sprintf("retry: %d\n\n", delay.Milliseconds()))
+}
+
+// Heartbeat changes how often a comment is sent to keep the connection open,
+// zero or less turns the heartbeat off
+func (s *EventStream) Heartbeat(interval time.Duration) {
+	select {
+	case s.heartbeat <- interval:
+	case <-s.done:
+	}
+}
+
+// Done is closed when the stream has been closed, the client went away
+// or the server is shutting down
+func (s *EventStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close ends the stream, the handler should return once it is done sending
+func (s *EventStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.close()
+}
+
+// close marks the stream as done, the caller holds s.mu
+func (s *EventStream) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// write sends the raw message out and flushes it,
+// a failed write means the client is gone and closes the stream
+func (s *EventStream) write(msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return ErrStreamClosed
+	default:
+	}
+
+	if _, err := s.w.Write([]byte(msg)); err != nil {
+		s.close()
+		return err
+	}
+	if err := s.w.FlushError(); err != nil {
+		s.close()
+		return err
+	}
+	return nil
+}
+
+// keepAlive sends the heartbeat comments until the stream
+// is closed, the client goes away or the server shuts down
+func (s *EventStream) keepAlive(gone, shuttingDown <-chan struct{}) {
+	ticker := time.NewTicker(DefaultSSEHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-gone:
+			s.Close()
+			return
+		case <-shuttingDown:
+			s.Close()
+			return
+		case <-s.done:
+			return
+		case interval := <-s.heartbeat:
+			if interval <= 0 {
+				ticker.Stop()
+				continue
+			}
+			ticker.Reset(interval)
+		case <-ticker.C:
+			s.Comment("heartbeat")
+		}
+	}
+}
+
+// singleLine keeps a field from breaking out onto a line of its own
+func singleLine(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
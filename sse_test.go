@@ -0,0 +1,68 @@
+package frodo
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEventStreamSend(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		id    string
+		data  interface{}
+		want  string
+	}{
+		{"plain", "msg", "1", "hello", "id: 1\nevent: msg\ndata: hello\n\n"},
+		{"LF", "", "", "a\nb", "data: a\ndata: b\n\n"},
+		{"CRLF", "", "", "a\r\nb", "data: a\ndata: b\n\n"},
+		{"lone CR", "msg", "1", "a\rb", "id: 1\nevent: msg\ndata: a\ndata: b\n\n"},
+		{"bytes", "", "", []byte("a\r\rb"), "data: a\ndata: \ndata: b\n\n"},
+		{"JSON", "", "", map[string]int{"n": 1}, "data: {\"n\":1}\n\n"},
+		{"line breaks in event and id", "a\rb\nc", "1\r\n2", "x", "id: 12\nevent: abc\ndata: x\n\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := New()
+			app.Get("/events", func(w http.ResponseWriter, r *Request) {
+				stream, err := r.SSE()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if err := stream.Send(test.event, test.id, test.data); err != nil {
+					t.Error(err)
+				}
+				stream.Close()
+			})
+			server := httptest.NewServer(app)
+			defer server.Close()
+
+			res, err := http.Get(server.URL + "/events")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Fatalf("Content-Type is %q", ct)
+			}
+
+			// read up to the blank line ending the event
+			var got strings.Builder
+			body := bufio.NewReader(res.Body)
+			for {
+				line, err := body.ReadString('\n')
+				got.WriteString(line)
+				if line == "\n" || err != nil {
+					break
+				}
+			}
+			if got.String() != test.want {
+				t.Errorf("sent %q, want %q", got.String(), test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package frodo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StaticOptions changes how Router.Static serves files
+type StaticOptions struct {
+	// Index are the files served for a directory, "index.html" by default
+	Index []string
+
+	// Browse lists the content of directories without an index file,
+	// otherwise they are not found
+	Browse bool
+
+	// MaxAge sets Cache-Control's max-age on the files served, zero leaves it out
+	MaxAge time.Duration
+
+	// Immutable picks the files that never change under their name, eg. those with
+	// a hash of their content in it, they are cached for a year. See HashedAsset.
+	Immutable func(name string) bool
+
+	// Precompressed serves "name.br" or "name.gz" in place of "name", when
+	// they exist and the client accepts them
+	Precompressed bool
+
+	// Fallback is served, uncached, in place of the files that do not exist,
+	// eg. "index.html" for a single page app doing its own routing.
+	// Without one the Router's NotFoundHandler answers.
+	Fallback string
+}
+
+// hashedAsset matches a hex hash of at least 8 characters between dots or dashes, eg.
+// "app.3f2a9c1b.js" or "logo-6b86b273ff34fce1.png"
+var hashedAsset = regexp.MustCompile(`[.-][0-9a-fA-F]{8,}\.[^/]+$`)
+
+// HashedAsset reports whether the name carries a hash of the content, as bundlers
+// name their output, to be used as StaticOptions.Immutable
+func HashedAsset(name string) bool {
+	return hashedAsset.MatchString(name)
+}
+
+// Static serves the files in fsys under the prefix, for both GET and HEAD requests.
+// Any fs.FS works, the os's with os.DirFS or those compiled in with embed.FS.
+// Byte ranges and conditional requests are supported, and files the file system
+// has no modification time for, as is the case with embed.FS, get an ETag
+// from their content.
+//
+//	//go:embed public
+//	var public embed.FS
+//
+//	assets, _ := fs.Sub(public, "public")
+//	router.Static("/assets", assets, frodo.StaticOptions{
+//		Immutable:     frodo.HashedAsset,
+//		Precompressed: true,
+//	})
+func (r *Router) Static(prefix string, fsys fs.FS, opts ...StaticOptions) {
+	var opt StaticOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if len(opt.Index) == 0 {
+		opt.Index = []string{"index.html"}
+	}
+
+	server := &staticServer{router: r, fsys: fsys, opts: opt}
+	prefix = strings.TrimSuffix(prefix, "/")
+	for _, method := range []string{"GET", "HEAD"} {
+		if prefix != "" {
+			r.Handle(method, prefix, server.serve)
+		}
+		r.Handle(method, prefix+"/*filepath", server.serve)
+	}
+}
+
+// staticServer answers the requests for the files under a Static prefix
+type staticServer struct {
+	router *Router
+	fsys   fs.FS
+	opts   StaticOptions
+	etags  sync.Map
+}
+
+func (s *staticServer) serve(w http.ResponseWriter, r *Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.GetParam("filepath")), "/")
+	if name == "" {
+		name = "."
+	}
+
+	info, err := fs.Stat(s.fsys, name)
+	if err == nil && info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			// relative links in the directory's index need the slash
+			target := path.Base(r.URL.Path) + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r.Request, target, http.StatusMovedPermanently)
+			return
+		}
+
+		dir := name
+		name = ""
+		for _, index := range s.opts.Index {
+			candidate := path.Join(dir, index)
+			if indexInfo, err := fs.Stat(s.fsys, candidate); err == nil && !indexInfo.IsDir() {
+				name, info = candidate, indexInfo
+				break
+			}
+		}
+		if name == "" {
+			if s.opts.Browse {
+				s.list(w, r, dir)
+				return
+			}
+			err = fs.ErrNotExist
+		}
+	}
+
+	if err != nil {
+		if s.opts.Fallback != "" && (errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid)) {
+			w.Header().Set("Cache-Control", "no-cache")
+			s.file(w, r, strings.TrimPrefix(path.Clean("/"+s.opts.Fallback), "/"), false)
+			return
+		}
+		s.error(w, r, err)
+		return
+	}
+
+	switch {
+	case s.opts.Immutable != nil && s.opts.Immutable(name):
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	case s.opts.MaxAge > 0:
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(s.opts.MaxAge.Seconds())))
+	}
+	s.file(w, r, name, true)
+}
+
+// file sends the file, or one of its precompressed variants
+func (s *staticServer) file(w http.ResponseWriter, r *Request, name string, precompressed bool) {
+	if precompressed && s.opts.Precompressed {
+		w.Header().Add("Vary", "Accept-Encoding")
+		accept := r.Header.Get("Accept-Encoding")
+		for _, variant := range []struct{ encoding, ext string }{{"br", ".br"}, {"gzip", ".gz"}} {
+			if !acceptsEncoding(accept, variant.encoding) {
+				continue
+			}
+			f, err := s.fsys.Open(name + variant.ext)
+			if err != nil {
+				continue
+			}
+			contentType := mime.TypeByExtension(path.Ext(name))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Encoding", variant.encoding)
+			s.content(w, r, name+variant.ext, f)
+			return
+		}
+	}
+
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+	s.content(w, r, name, f)
+}
+
+// content hands the open file to http.ServeContent, which takes care of
+// ranges, conditional requests and the Content-Type
+func (s *staticServer) content(w http.ResponseWriter, r *Request, name string, f fs.File) {
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		s.error(w, r, fs.ErrNotExist)
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			s.error(w, r, err)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+
+	if info.ModTime().IsZero() && w.Header().Get("ETag") == "" {
+		if etag, err := s.etag(name, content); err == nil {
+			w.Header().Set("ETag", etag)
+		}
+	}
+	http.ServeContent(w, r.Request, path.Base(name), info.ModTime(), content)
+}
+
+// etag hashes the content of a file, once, for file systems that cannot tell when it was modified
+func (s *staticServer) etag(name string, content io.ReadSeeker) (string, error) {
+	if etag, ok := s.etags.Load(name); ok {
+		return etag.(string), nil
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	s.etags.Store(name, etag)
+	return etag, nil
+}
+
+// list writes a page linking to the entries of the directory
+func (s *staticServer) list(w http.ResponseWriter, r *Request, dir string) {
+	entries, err := fs.ReadDir(s.fsys, dir)
+	if err != nil {
+		s.error(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!doctype html>\n<title>%s</title>\n<pre>\n", html.EscapeString(r.URL.Path))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		link := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", html.EscapeString(link.String()), html.EscapeString(name))
+	}
+	fmt.Fprint(w, "</pre>\n")
+}
+
+// error answers for a file that could not be served, those missing through
+// the Router's NotFoundHandler
+func (s *staticServer) error(w http.ResponseWriter, r *Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		s.router.notFound(w, r)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// acceptsEncoding checks the Accept-Encoding header lists the encoding, without a q of 0
+func acceptsEncoding(header, encoding string) bool {
+	for _, value := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(value), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), encoding) {
+			continue
+		}
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !found {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
@@ -0,0 +1,307 @@
+package frodo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrFileNotFound is returned by a Storage when no file is stored under the key asked for
+var ErrFileNotFound = errors.New("frodo: stored file not found")
+
+// Storage is a backend uploaded files are moved into. Keys are slash separated
+// paths, eg. "avatars/42.png", relative to the root of the backend.
+type Storage interface {
+	// Put stores the body under the key, replacing any file already stored there
+	// unless PutOptions.NoReplace says otherwise
+	Put(ctx context.Context, key string, body io.Reader, contentType string, opts ...PutOptions) (*StoredFile, error)
+
+	// Get opens the file stored under the key, the caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, *StoredFile, error)
+
+	// Delete removes the file stored under the key
+	Delete(ctx context.Context, key string) error
+
+	// Exists checks whether a file is stored under the key
+	Exists(ctx context.Context, key string) (bool, error)
+
+	// URL returns the address the file stored under the key is served from
+	URL(key string) string
+}
+
+// PutOptions changes how a Storage stores a file
+type PutOptions struct {
+	// NoReplace fails the Put with ErrFileExists when a file is already stored
+	// under the key. The check and the store are one step, so of two files
+	// put under the same key at the same time only one is stored.
+	NoReplace bool
+}
+
+// putOptions returns the options passed to a Put, if any
+func putOptions(opts []PutOptions) PutOptions {
+	if len(opts) > 0 {
+		return opts[0]
+	}
+	return PutOptions{}
+}
+
+// StoredFile describes a file kept in a Storage
+type StoredFile struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+	URL         string
+	Storage     Storage
+}
+
+// cleanKey turns a key into a slash separated path that cannot climb
+// out of the storage root, eg. "../../etc/passwd" becomes "etc/passwd"
+func cleanKey(key string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(key, "\\", "/")), "/")
+}
+
+// joinURL appends the key, escaped, to a base URL
+func joinURL(base, key string) string {
+	if base == "" {
+		return ""
+	}
+	segments := strings.Split(key, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.Join(segments, "/")
+}
+
+// LocalStorage keeps files in a directory on the local file system
+type LocalStorage struct {
+	// Root is the directory files are stored in
+	Root string
+
+	// BaseURL is where Root is served from, eg. "/uploads"
+	BaseURL string
+
+	// DirPerm and FilePerm are the permissions directories and files are created with
+	DirPerm, FilePerm os.FileMode
+}
+
+// NewLocalStorage returns a LocalStorage keeping files in the root directory,
+// served from baseURL
+func NewLocalStorage(root, baseURL string) *LocalStorage {
+	return &LocalStorage{
+		Root:     root,
+		BaseURL:  baseURL,
+		DirPerm:  0755,
+		FilePerm: 0644,
+	}
+}
+
+// path returns where on disk the key is kept
+func (s *LocalStorage) path(key string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(cleanKey(key)))
+}
+
+// Put writes the body to a temporary file next to the one the key points to,
+// then renames it into place so that readers never see a partly written file.
+// Missing directories are created with DirPerm. With NoReplace the file is
+// hard linked into place instead, which fails when the name is taken.
+func (s *LocalStorage) Put(ctx context.Context, key string, body io.Reader, contentType string, opts ...PutOptions) (*StoredFile, error) {
+	key = cleanKey(key)
+	name := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(name), s.DirPerm); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	size, err := io.Copy(tmp, body)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), s.FilePerm)
+	}
+	if err == nil {
+		if putOptions(opts).NoReplace {
+			err = os.Link(tmp.Name(), name)
+			os.Remove(tmp.Name())
+			if errors.Is(err, fs.ErrExist) {
+				err = ErrFileExists
+			}
+		} else {
+			err = os.Rename(tmp.Name(), name)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return &StoredFile{
+		Key:         key,
+		Size:        size,
+		ContentType: contentType,
+		ModTime:     time.Now(),
+		URL:         s.URL(key),
+		Storage:     s,
+	}, nil
+}
+
+// Get opens the file the key points to, the *os.File returned can be seeked
+func (s *LocalStorage) Get(ctx context.Context, key string) (io.ReadCloser, *StoredFile, error) {
+	key = cleanKey(key)
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, &StoredFile{
+		Key:     key,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		URL:     s.URL(key),
+		Storage: s,
+	}, nil
+}
+
+// Delete removes the file the key points to
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrFileNotFound
+	}
+	return err
+}
+
+// Exists checks whether the file the key points to is there
+func (s *LocalStorage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := os.Stat(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// URL returns BaseURL joined with the key
+func (s *LocalStorage) URL(key string) string {
+	return joinURL(s.BaseURL, cleanKey(key))
+}
+
+// MemoryStorage keeps files in memory, handy in tests and for short lived files
+type MemoryStorage struct {
+	// BaseURL is prefixed to the keys to build their URLs
+	BaseURL string
+
+	mu    sync.RWMutex
+	files map[string]memoryFile
+}
+
+type memoryFile struct {
+	data []byte
+	info StoredFile
+}
+
+// NewMemoryStorage returns an empty MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string]memoryFile)}
+}
+
+// Put reads the body into memory
+func (s *MemoryStorage) Put(ctx context.Context, key string, body io.Reader, contentType string, opts ...PutOptions) (*StoredFile, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	key = cleanKey(key)
+	info := StoredFile{
+		Key:         key,
+		Size:        int64(len(data)),
+		ContentType: contentType,
+		ModTime:     time.Now(),
+		URL:         s.URL(key),
+		Storage:     s,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = make(map[string]memoryFile)
+	}
+	if _, taken := s.files[key]; taken && putOptions(opts).NoReplace {
+		return nil, ErrFileExists
+	}
+	s.files[key] = memoryFile{data: data, info: info}
+
+	stored := info
+	return &stored, nil
+}
+
+// Get returns a reader over the stored bytes, it can be seeked
+func (s *MemoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, *StoredFile, error) {
+	s.mu.RLock()
+	file, ok := s.files[cleanKey(key)]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, nil, ErrFileNotFound
+	}
+
+	info := file.info
+	return memoryReader{bytes.NewReader(file.data)}, &info, nil
+}
+
+// Delete drops the file from memory
+func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key = cleanKey(key)
+	if _, ok := s.files[key]; !ok {
+		return ErrFileNotFound
+	}
+	delete(s.files, key)
+	return nil
+}
+
+// Exists checks whether the key is held in memory
+func (s *MemoryStorage) Exists(ctx context.Context, key string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.files[cleanKey(key)]
+	return ok, nil
+}
+
+// URL returns BaseURL joined with the key
+func (s *MemoryStorage) URL(key string) string {
+	return joinURL(s.BaseURL, cleanKey(key))
+}
+
+// memoryReader lets a bytes.Reader be handed out as an io.ReadCloser
+// while still being an io.ReadSeeker
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error { return nil }
@@ -0,0 +1,294 @@
+package frodo
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultCertCheckInterval is how often a CertManager looks for renewed certificates on disk
+const DefaultCertCheckInterval = 10 * time.Second
+
+// CertManager serves certificates loaded from disk, picking the one matching the
+// name the client asks for (SNI). Certificates replaced on disk, eg. renewed by
+// certbot, are picked up without a restart.
+//
+//	certs := frodo.NewCertManager()
+//	certs.Add("/etc/ssl/example.com.crt", "/etc/ssl/example.com.key")
+//	certs.Add("/etc/ssl/example.org.crt", "/etc/ssl/example.org.key")
+//	app.Run(ctx, frodo.Server{Addr: ":443", TLSConfig: certs.TLSConfig()})
+type CertManager struct {
+	// CheckInterval is how often the files are checked for changes,
+	// DefaultCertCheckInterval if zero
+	CheckInterval time.Duration
+
+	// Logger receives the errors reloading a certificate,
+	// if it is not set the standard logger is used
+	Logger *log.Logger
+
+	mu        sync.RWMutex
+	pairs     []*certPair
+	lastCheck time.Time
+}
+
+// certPair is a certificate and the files it was loaded from
+type certPair struct {
+	certFile, keyFile string
+	cert              *tls.Certificate
+	certMod, keyMod   time.Time
+}
+
+// NewCertManager returns a CertManager without any certificates, see Add
+func NewCertManager() *CertManager {
+	return &CertManager{}
+}
+
+// Add loads a certificate and its key, PEM encoded. The first one added
+// is served to the clients that do not ask for a name, or one there is no
+// certificate for.
+func (m *CertManager) Add(certFile, keyFile string) error {
+	pair := &certPair{certFile: certFile, keyFile: keyFile}
+	if _, err := pair.load(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pairs = append(m.pairs, pair)
+	m.lastCheck = time.Now()
+	return nil
+}
+
+// Reload reloads the certificates whose files have changed since they were loaded.
+// A certificate that fails to load is logged and the previous one kept.
+func (m *CertManager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastCheck = time.Now()
+
+	var errs []error
+	for _, pair := range m.pairs {
+		reloaded, err := pair.load()
+		if err != nil {
+			logf(m.Logger, "[ERROR] Keeping the certificate in %s, reloading failed: %s", pair.certFile, err)
+			errs = append(errs, err)
+			continue
+		}
+		if reloaded {
+			logf(m.Logger, "[LOG] Reloaded the certificate in %s", pair.certFile)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetCertificate picks the certificate for the TLS handshake, it is
+// what tls.Config.GetCertificate should be set to
+func (m *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	interval := m.CheckInterval
+	if interval <= 0 {
+		interval = DefaultCertCheckInterval
+	}
+	m.mu.RLock()
+	stale := time.Since(m.lastCheck) > interval
+	m.mu.RUnlock()
+	if stale {
+		m.Reload()
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.pairs) == 0 {
+		return nil, errors.New("frodo: the CertManager has no certificates")
+	}
+	if hello.ServerName != "" {
+		for _, pair := range m.pairs {
+			if hello.SupportsCertificate(pair.cert) == nil {
+				return pair.cert, nil
+			}
+		}
+	}
+	return m.pairs[0].cert, nil
+}
+
+// TLSConfig returns a tls.Config serving the manager's certificates
+func (m *CertManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: m.GetCertificate,
+	}
+}
+
+// load (re)loads the pair if its files changed, reporting whether they did
+func (p *certPair) load() (bool, error) {
+	certInfo, err := os.Stat(p.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyInfo, err := os.Stat(p.keyFile)
+	if err != nil {
+		return false, err
+	}
+	if p.cert != nil && certInfo.ModTime().Equal(p.certMod) && keyInfo.ModTime().Equal(p.keyMod) {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		return false, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return false, err
+		}
+	}
+	p.cert, p.certMod, p.keyMod = &cert, certInfo.ModTime(), keyInfo.ModTime()
+	return true, nil
+}
+
+// ServeTLS serves the application over HTTPS, with the certificate and key in the
+// files given, until the process receives SIGINT or SIGTERM. The certificate is
+// reloaded when the files change. See Run and Server for the rest of the config,
+// eg. HTTPRedirectAddr to redirect plain HTTP requests over to HTTPS.
+//
+// Given no files a self-signed certificate for localhost is generated, see
+// DevCertificate. Browsers will warn about it, it is only fit for development.
+//
+//	app.ServeTLS(":8443", "", "") // https://localhost:8443
+func (r *Router) ServeTLS(addr, certFile, keyFile string, config ...Server) error {
+	var cfg Server
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	cfg.Addr = addr
+
+	if certFile == "" && keyFile == "" {
+		var err error
+		if certFile, keyFile, err = DevCertificate(""); err != nil {
+			return err
+		}
+		logf(r.Logger, "[LOG] Serving the self-signed development certificate in %s", certFile)
+	}
+
+	certs := NewCertManager()
+	certs.Logger = r.Logger
+	if err := certs.Add(certFile, keyFile); err != nil {
+		return err
+	}
+	cfg.TLSConfig = certs.TLSConfig()
+
+	return r.Run(context.Background(), cfg)
+}
+
+// DevCertificate returns the files of a self-signed certificate for localhost, 127.0.0.1,
+// ::1 and the extra hosts given, generating it unless one cached in dir still covers them
+// for more than a week. An empty dir means a "frodo" directory in the user's cache directory.
+func DevCertificate(dir string, hosts ...string) (certFile, keyFile string, err error) {
+	if dir == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			cache = os.TempDir()
+		}
+		dir = filepath.Join(cache, "frodo")
+	}
+	certFile = filepath.Join(dir, "dev-cert.pem")
+	keyFile = filepath.Join(dir, "dev-key.pem")
+	hosts = append([]string{"localhost", "127.0.0.1", "::1"}, hosts...)
+
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && time.Until(leaf.NotAfter) > 7*24*time.Hour && coversHosts(leaf, hosts) {
+			return certFile, keyFile, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"frodo development certificate"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+// coversHosts checks the certificate is valid for all the hosts
+func coversHosts(cert *x509.Certificate, hosts []string) bool {
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// redirectToHTTPS sends the requests over to the same host and path, over HTTPS on the port given
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			host = "[" + host + "]"
+		}
+
+		code := http.StatusMovedPermanently
+		if req.Method != "GET" && req.Method != "HEAD" {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, req, fmt.Sprintf("https://%s%s", host, req.URL.RequestURI()), code)
+	})
+}
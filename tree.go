@@ -43,6 +43,7 @@ type node struct {
 	indices   string
 	children  []*node
 	handle    []Middleware
+	route     *route
 	priority  uint32
 }
 
@@ -74,7 +75,7 @@ func (n *node) incrementChildPrio(pos int) int {
 
 // addRoute adds a node with the given handle to the path.
 // Not concurrency-safe!
-func (n *node) addRoute(path string, handle []Middleware) {
+func (n *node) addRoute(path string, handle []Middleware, rt *route) {
 	fullPath := path
 	n.priority++
 	numParams := countParams(path)
@@ -105,6 +106,7 @@ func (n *node) addRoute(path string, handle []Middleware) {
 					indices:   n.indices,
 					children:  n.children,
 					handle:    n.handle,
+					route:     n.route,
 					priority:  n.priority - 1,
 				}
 
@@ -120,6 +122,7 @@ func (n *node) addRoute(path string, handle []Middleware) {
 				n.indices = string([]byte{n.path[i]})
 				n.path = path[:i]
 				n.handle = make([]Middleware, 0)
+				n.route = nil
 				n.wildChild = false
 			}
 
@@ -179,7 +182,7 @@ func (n *node) addRoute(path string, handle []Middleware) {
 					n.incrementChildPrio(len(n.indices) - 1)
 					n = child
 				}
-				n.insertChild(numParams, path, fullPath, handle)
+				n.insertChild(numParams, path, fullPath, handle, rt)
 				return
 
 			} else if i == len(path) { // Make node a (in-path) leaf
@@ -187,16 +190,17 @@ func (n *node) addRoute(path string, handle []Middleware) {
 					panic("a handle is already registered for path '" + fullPath + "'")
 				}
 				n.handle = handle
+				n.route = rt
 			}
 			return
 		}
 	} else { // Empty tree
-		n.insertChild(numParams, path, fullPath, handle)
+		n.insertChild(numParams, path, fullPath, handle, rt)
 		n.nType = root
 	}
 }
 
-func (n *node) insertChild(numParams uint8, path, fullPath string, handle []Middleware) {
+func (n *node) insertChild(numParams uint8, path, fullPath string, handle []Middleware, rt *route) {
 	var offset int // already handled bytes of the path
 
 	// find prefix until first wildcard (beginning with ':'' or '*'')
@@ -296,6 +300,7 @@ func (n *node) insertChild(numParams uint8, path, fullPath string, handle []Midd
 				nType:     catchAll,
 				maxParams: 1,
 				handle:    handle,
+				route:     rt,
 				priority:  1,
 			}
 			n.children = []*node{child}
@@ -307,6 +312,7 @@ func (n *node) insertChild(numParams uint8, path, fullPath string, handle []Midd
 	// insert remaining path part and handle to the leaf
 	n.path = path[offset:]
 	n.handle = handle
+	n.route = rt
 }
 
 // Returns the handle registered with the given path (key). The values of
@@ -314,7 +320,7 @@ func (n *node) insertChild(numParams uint8, path, fullPath string, handle []Midd
 // If no handle can be found, a TSR (trailing slash redirect) recommendation is
 // made if a handle exists with an extra (without the) trailing slash for the
 // given path.
-func (n *node) getValue(path string) (handle []Middleware, p Params, tsr bool) {
+func (n *node) getValue(path string) (handle []Middleware, rt *route, p Params, tsr bool) {
 walk: // Outer loop for walking the tree
 	for {
 		if len(path) > len(n.path) {
@@ -372,6 +378,7 @@ walk: // Outer loop for walking the tree
 					}
 
 					if handle = n.handle; handle != nil {
+						rt = n.route
 						return
 					} else if len(n.children) == 1 {
 						// No handle found. Check if a handle for this path + a
@@ -391,7 +398,7 @@ walk: // Outer loop for walking the tree
 					key := n.path[2:]
 					p[key] = path
 
-					handle = n.handle
+					handle, rt = n.handle, n.route
 					return
 
 				default:
@@ -402,6 +409,7 @@ walk: // Outer loop for walking the tree
 			// We should have reached the node containing the handle.
 			// Check if this node has a handle registered.
 			if handle = n.handle; handle != nil {
+				rt = n.route
 				return
 			}
 
@@ -0,0 +1,515 @@
+package frodo
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TusVersion is the version of the tus resumable upload protocol implemented
+const TusVersion = "1.0.0"
+
+// tusExtensions are the protocol extensions supported
+const tusExtensions = "creation,creation-with-upload,creation-defer-length,termination,expiration"
+
+// TusOptions configures a TusHandler
+type TusOptions struct {
+	// Dir is where uploads are assembled before they are handed to the Storage,
+	// a "frodo-tus" directory in the system's temporary directory by default
+	Dir string
+
+	// MaxSize caps the size of an upload in bytes, zero means no limit
+	MaxSize int64
+
+	// Expiration is how long an unfinished upload is kept after it was last touched,
+	// 24 hours by default
+	Expiration time.Duration
+
+	// Key picks the key the finished upload is stored under, by default
+	// its ID followed by the sanitized "filename" from its metadata
+	Key func(upload *TusUpload) string
+
+	// OnComplete is called once an upload has been assembled and stored
+	OnComplete func(upload *TusUpload, stored *StoredFile)
+}
+
+// TusUpload is the state of a resumable upload
+type TusUpload struct {
+	ID       string            `json:"id"`
+	Length   int64             `json:"length"` // -1 until the client says, when deferred
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata"`
+	Expires  time.Time         `json:"expires"`
+}
+
+// TusHandler is an http.Handler speaking the tus 1.0 resumable upload protocol,
+// see https://tus.io/protocols/resumable-upload. Chunks are appended to a file
+// in Dir, when the last one arrives the file is moved into the Storage.
+type TusHandler struct {
+	storage Storage
+	opts    TusOptions
+
+	mu          sync.Mutex
+	locks       map[string]*sync.Mutex
+	lastCleanup time.Time
+}
+
+// Tus returns a tus server storing finished uploads in the given Storage,
+// mount it under the path uploads are sent to
+//
+//	r.Mount("/uploads", frodo.Tus(storage))
+func Tus(storage Storage, opts ...TusOptions) *TusHandler {
+	var opt TusOptions
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+	if opt.Dir == "" {
+		opt.Dir = filepath.Join(os.TempDir(), "frodo-tus")
+	}
+	if opt.Expiration == 0 {
+		opt.Expiration = 24 * time.Hour
+	}
+	return &TusHandler{storage: storage, opts: opt, locks: make(map[string]*sync.Mutex)}
+}
+
+// ServeHTTP answers the tus requests
+func (t *TusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", TusVersion)
+
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" {
+		method = strings.ToUpper(override)
+	}
+
+	if method == "OPTIONS" {
+		w.Header().Set("Tus-Version", TusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		if t.opts.MaxSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(t.opts.MaxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != TusVersion {
+		w.Header().Set("Tus-Version", TusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(r.URL.Path, "/")
+	if method == "POST" {
+		if id != "" {
+			http.Error(w, "uploads are created on the collection", http.StatusMethodNotAllowed)
+			return
+		}
+		t.create(w, r)
+		return
+	}
+
+	id = path.Base("/" + id)
+	if !validTusID(id) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// unknown uploads are turned away before a lock is kept for them
+	if _, err := os.Stat(t.infoPath(id)); errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+
+	lock := t.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, err := t.load(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		// completed or removed while waiting for the lock
+		t.remove(id)
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if time.Now().After(upload.Expires) {
+		t.remove(id)
+		http.Error(w, "upload expired", http.StatusGone)
+		return
+	}
+
+	switch method {
+	case "HEAD":
+		t.head(w, upload)
+	case "PATCH":
+		t.patch(w, r, upload)
+	case "DELETE":
+		t.remove(id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// Cleanup removes the unfinished uploads that have expired
+func (t *TusHandler) Cleanup() error {
+	entries, err := os.ReadDir(t.opts.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		id, isInfo := strings.CutSuffix(entry.Name(), ".info")
+		if !isInfo || !validTusID(id) {
+			continue
+		}
+		lock := t.lock(id)
+		lock.Lock()
+		if upload, err := t.load(id); err == nil && time.Now().After(upload.Expires) {
+			t.remove(id)
+		}
+		lock.Unlock()
+	}
+	return nil
+}
+
+// create starts a new upload, appending the body to it if one was sent along
+func (t *TusHandler) create(w http.ResponseWriter, r *http.Request) {
+	upload := &TusUpload{Length: -1, Metadata: parseTusMetadata(r.Header.Get("Upload-Metadata"))}
+
+	if length := r.Header.Get("Upload-Length"); length != "" {
+		size, err := strconv.ParseInt(length, 10, 64)
+		if err != nil || size < 0 {
+			http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+			return
+		}
+		upload.Length = size
+	} else if r.Header.Get("Upload-Defer-Length") != "1" {
+		http.Error(w, "Upload-Length or Upload-Defer-Length is required", http.StatusBadRequest)
+		return
+	}
+	if t.opts.MaxSize > 0 && upload.Length > t.opts.MaxSize {
+		http.Error(w, "upload larger than Tus-Max-Size", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	upload.ID = hex.EncodeToString(id[:])
+	upload.Expires = time.Now().Add(t.opts.Expiration)
+
+	if err := os.MkdirAll(t.opts.Dir, 0700); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	data, err := os.OpenFile(t.dataPath(upload.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err == nil {
+		err = data.Close()
+	}
+	if err == nil {
+		err = t.save(upload)
+	}
+	if err != nil {
+		t.remove(upload.ID)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	base := r.URL.Path
+	if uri, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		// the path as the client sent it, before any prefix was stripped
+		base = uri.Path
+	}
+	w.Header().Set("Location", strings.TrimSuffix(base, "/")+"/"+upload.ID)
+
+	t.sweep()
+
+	lock := t.lock(upload.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if r.Header.Get("Content-Type") == "application/offset+octet-stream" {
+		if !t.append(w, r, upload) {
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	} else if upload.Length == 0 {
+		// an empty file is complete as soon as it is created
+		if err := t.complete(r, upload); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head reports how far the upload has got
+func (t *TusHandler) head(w http.ResponseWriter, upload *TusUpload) {
+	header := w.Header()
+	header.Set("Cache-Control", "no-store")
+	header.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Length < 0 {
+		header.Set("Upload-Defer-Length", "1")
+	} else {
+		header.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	}
+	if len(upload.Metadata) > 0 {
+		header.Set("Upload-Metadata", encodeTusMetadata(upload.Metadata))
+	}
+	header.Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch appends a chunk at the offset the client says it is at
+func (t *TusHandler) patch(w http.ResponseWriter, r *http.Request, upload *TusUpload) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	if offset != upload.Offset {
+		http.Error(w, "Upload-Offset does not match the upload's offset", http.StatusConflict)
+		return
+	}
+
+	if length := r.Header.Get("Upload-Length"); length != "" && upload.Length < 0 {
+		size, err := strconv.ParseInt(length, 10, 64)
+		if err != nil || size < upload.Offset {
+			http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+			return
+		}
+		if t.opts.MaxSize > 0 && size > t.opts.MaxSize {
+			http.Error(w, "upload larger than Tus-Max-Size", http.StatusRequestEntityTooLarge)
+			return
+		}
+		upload.Length = size
+	}
+
+	upload.Expires = time.Now().Add(t.opts.Expiration)
+	if !t.append(w, r, upload) {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// append writes the request body to the end of the upload, storing it once it is complete.
+// The bytes received are kept even when the client drops, so it can resume from there.
+// It answers the client itself and returns false when something went wrong.
+func (t *TusHandler) append(w http.ResponseWriter, r *http.Request, upload *TusUpload) bool {
+	limit := int64(-1)
+	switch {
+	case upload.Length >= 0:
+		limit = upload.Length - upload.Offset
+	case t.opts.MaxSize > 0:
+		limit = t.opts.MaxSize - upload.Offset
+	}
+
+	data, err := os.OpenFile(t.dataPath(upload.ID), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return false
+	}
+
+	body := io.Reader(r.Body)
+	if limit >= 0 {
+		body = io.LimitReader(r.Body, limit+1)
+	}
+	written, copyErr := io.Copy(data, body)
+	if limit >= 0 && written > limit {
+		// drop the byte that overflowed
+		data.Truncate(upload.Offset + limit)
+		written = limit
+		copyErr = errTusOverflow
+	}
+	closeErr := data.Close()
+
+	upload.Offset += written
+	if err := t.save(upload); err != nil || closeErr != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return false
+	}
+	if copyErr == errTusOverflow {
+		http.Error(w, "chunk goes past Upload-Length", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if copyErr != nil {
+		http.Error(w, "chunk was cut short", http.StatusBadRequest)
+		return false
+	}
+
+	if upload.Length >= 0 && upload.Offset == upload.Length {
+		if err := t.complete(r, upload); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return false
+		}
+	}
+	return true
+}
+
+var errTusOverflow = errors.New("frodo: tus chunk goes past the upload's length")
+
+// complete hands the assembled file over to the Storage
+func (t *TusHandler) complete(r *http.Request, upload *TusUpload) error {
+	data, err := os.Open(t.dataPath(upload.ID))
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	key := upload.ID
+	if t.opts.Key != nil {
+		key = t.opts.Key(upload)
+	} else if name := upload.Metadata["filename"]; name != "" {
+		key = upload.ID + "/" + SanitizeFilename(name)
+	}
+
+	contentType := upload.Metadata["filetype"]
+	if contentType == "" {
+		head := make([]byte, 512)
+		n, _ := data.ReadAt(head, 0)
+		contentType = DetectContentType(head[:n])
+	}
+
+	stored, err := t.storage.Put(r.Context(), key, data, contentType)
+	if err != nil {
+		return err
+	}
+	if t.opts.OnComplete != nil {
+		t.opts.OnComplete(upload, stored)
+	}
+	t.remove(upload.ID)
+	return nil
+}
+
+// sweep runs Cleanup in the background every so often
+func (t *TusHandler) sweep() {
+	t.mu.Lock()
+	due := time.Since(t.lastCleanup) > t.opts.Expiration/4
+	if due {
+		t.lastCleanup = time.Now()
+	}
+	t.mu.Unlock()
+
+	if due {
+		go t.Cleanup()
+	}
+}
+
+// lock returns the mutex guarding the upload
+func (t *TusHandler) lock(id string) *sync.Mutex {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lock, ok := t.locks[id]
+	if !ok {
+		lock = new(sync.Mutex)
+		t.locks[id] = lock
+	}
+	return lock
+}
+
+func (t *TusHandler) infoPath(id string) string {
+	return filepath.Join(t.opts.Dir, id+".info")
+}
+
+func (t *TusHandler) dataPath(id string) string {
+	return filepath.Join(t.opts.Dir, id+".bin")
+}
+
+// load reads the state of the upload
+func (t *TusHandler) load(id string) (*TusUpload, error) {
+	raw, err := os.ReadFile(t.infoPath(id))
+	if err != nil {
+		return nil, err
+	}
+	upload := new(TusUpload)
+	return upload, json.Unmarshal(raw, upload)
+}
+
+// save writes the state of the upload, replacing the previous one in one go
+func (t *TusHandler) save(upload *TusUpload) error {
+	raw, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	tmp := t.infoPath(upload.ID) + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.infoPath(upload.ID))
+}
+
+// remove deletes what is kept of the upload, the caller holds its lock
+func (t *TusHandler) remove(id string) {
+	os.Remove(t.infoPath(id))
+	os.Remove(t.dataPath(id))
+
+	t.mu.Lock()
+	delete(t.locks, id)
+	t.mu.Unlock()
+}
+
+// validTusID checks the id is one this handler would have made
+func validTusID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// parseTusMetadata decodes the Upload-Metadata header: comma separated
+// keys, each followed by a space and its base64 encoded value
+func parseTusMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata
+}
+
+// encodeTusMetadata is the reverse of parseTusMetadata
+func encodeTusMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+	return strings.Join(pairs, ",")
+}
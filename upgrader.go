@@ -0,0 +1,291 @@
+package frodo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultUpgradeTimeout is how long a new process is given to report it is ready
+const DefaultUpgradeTimeout = 30 * time.Second
+
+// the environment the Upgrader hands the listeners over through
+const (
+	upgradeListenersEnv = "FRODO_UPGRADE_LISTENERS"
+	upgradeReadyEnv     = "FRODO_UPGRADE_READY"
+)
+
+// ErrUpgradeInProgress is returned by Upgrade while another upgrade is running,
+// or once one has succeeded
+var ErrUpgradeInProgress = errors.New("frodo: upgrade already in progress")
+
+// Upgrader restarts the application without dropping a connection. On SIGHUP it
+// starts the executable again, a new binary if it was replaced, handing it the
+// listening sockets. Once the new process reports it is ready the old one stops
+// accepting and drains its in-flight requests, if it fails the old one carries on.
+//
+//	upgrader, err := frodo.NewUpgrader()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	app.Run(ctx, frodo.Server{Addr: ":8080", Upgrader: upgrader})
+//
+// To try it, start the binary, rebuild it and send the running process a SIGHUP:
+//
+//	go build -o app && ./app &
+//	go build -o app && kill -HUP $!
+//
+// The process id changes with every upgrade, process managers must be told, see PIDFile.
+type Upgrader struct {
+	// ReadyTimeout is how long the new process is given to report it is ready,
+	// DefaultUpgradeTimeout if zero
+	ReadyTimeout time.Duration
+
+	// PIDFile, when set, gets the process id written to it once the process is ready
+	PIDFile string
+
+	// Logger receives the progress of upgrades, if it is not set the standard logger is used
+	Logger *log.Logger
+
+	mu        sync.Mutex
+	inherited map[string][]net.Listener
+	handoff   []upgradeListener
+	ready     *os.File
+	upgrading bool
+	exit      chan struct{}
+	signals   chan os.Signal
+}
+
+// upgradeListener is a listener to hand over, with the address it was opened for
+type upgradeListener struct {
+	addr string
+	ln   net.Listener
+}
+
+// NewUpgrader takes over the listeners handed over by the previous process, if any,
+// and starts listening for SIGHUP
+func NewUpgrader() (*Upgrader, error) {
+	u := &Upgrader{
+		inherited: make(map[string][]net.Listener),
+		exit:      make(chan struct{}),
+		signals:   make(chan os.Signal, 1),
+	}
+
+	if addrs := os.Getenv(upgradeListenersEnv); addrs != "" {
+		for i, addr := range strings.Split(addrs, "\n") {
+			fd := listenFDsStart + i
+			syscall.CloseOnExec(fd)
+			file := os.NewFile(uintptr(fd), addr)
+			ln, err := net.FileListener(file)
+			file.Close()
+			if err != nil {
+				return nil, fmt.Errorf("frodo: listener for %s handed over: %w", addr, err)
+			}
+			u.inherited[addr] = append(u.inherited[addr], ln)
+		}
+	}
+	if fd, err := strconv.Atoi(os.Getenv(upgradeReadyEnv)); err == nil {
+		syscall.CloseOnExec(fd)
+		u.ready = os.NewFile(uintptr(fd), "ready")
+	}
+	os.Unsetenv(upgradeListenersEnv)
+	os.Unsetenv(upgradeReadyEnv)
+
+	signal.Notify(u.signals, syscall.SIGHUP)
+	go u.watch()
+	return u, nil
+}
+
+// HasParent reports whether the process was started by an upgrade
+func (u *Upgrader) HasParent() bool {
+	return u.ready != nil
+}
+
+// Listen returns the listeners for the address handed over by the previous
+// process, or opens them as the package's Listen does. Either way they are
+// handed over to the next process on upgrade.
+func (u *Upgrader) Listen(addr string, socketMode os.FileMode) ([]net.Listener, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	listeners, ok := u.inherited[addr]
+	if ok {
+		delete(u.inherited, addr)
+	} else {
+		var err error
+		if listeners, err = Listen(addr, socketMode); err != nil {
+			return nil, err
+		}
+	}
+	for _, ln := range listeners {
+		u.handoff = append(u.handoff, upgradeListener{addr: addr, ln: ln})
+	}
+	return listeners, nil
+}
+
+// Ready tells the previous process this one is serving, so it can drain and exit.
+// The listeners handed over and not taken through Listen are closed.
+// Run calls it once the server is up.
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for addr, listeners := range u.inherited {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+		delete(u.inherited, addr)
+	}
+
+	if u.PIDFile != "" {
+		if err := os.WriteFile(u.PIDFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	if u.ready == nil {
+		return nil
+	}
+	_, err := u.ready.Write([]byte{1})
+	u.ready.Close()
+	u.ready = nil
+	return err
+}
+
+// Exit is closed once an upgrade succeeded, the process should then shut down
+func (u *Upgrader) Exit() <-chan struct{} {
+	return u.exit
+}
+
+// Stop stops listening for SIGHUP
+func (u *Upgrader) Stop() {
+	signal.Stop(u.signals)
+}
+
+// watch upgrades on every SIGHUP
+func (u *Upgrader) watch() {
+	for range u.signals {
+		if err := u.Upgrade(); err != nil {
+			logf(u.Logger, "[ERROR] Upgrade failed: %s", err)
+		}
+	}
+}
+
+// Upgrade starts the new process, hands it the listeners and waits for it to be ready.
+// It returns nil once it is, and Exit is closed.
+func (u *Upgrader) Upgrade() error {
+	u.mu.Lock()
+	if u.upgrading {
+		u.mu.Unlock()
+		return ErrUpgradeInProgress
+	}
+	u.upgrading = true
+	handoff := append([]upgradeListener(nil), u.handoff...)
+	u.mu.Unlock()
+
+	err := u.upgrade(handoff)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err != nil {
+		u.upgrading = false
+		return err
+	}
+	// the socket files now belong to the new process
+	for _, l := range handoff {
+		if unix, ok := l.ln.(*net.UnixListener); ok {
+			unix.SetUnlinkOnClose(false)
+		}
+	}
+	close(u.exit)
+	return nil
+}
+
+func (u *Upgrader) upgrade(handoff []upgradeListener) error {
+	executable, err := os.Executable()
+	if err != nil {
+		executable = os.Args[0]
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	var addrs []string
+	for _, l := range handoff {
+		filer, ok := l.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("frodo: the listener for %s cannot be handed over", l.addr)
+		}
+		file, err := filer.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		addrs = append(addrs, l.addr)
+	}
+
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyRead.Close()
+	files = append(files, readyWrite)
+
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, upgradeListenersEnv+"=") && !strings.HasPrefix(kv, upgradeReadyEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env,
+		upgradeListenersEnv+"="+strings.Join(addrs, "\n"),
+		upgradeReadyEnv+"="+strconv.Itoa(listenFDsStart+len(addrs)),
+	)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// only the new process holds the write end now, so
+	// the read fails as soon as it exits
+	readyWrite.Close()
+	files = files[:len(files)-1]
+	logf(u.Logger, "[LOG] Upgrading, started %s as process %d", executable, cmd.Process.Pid)
+
+	timeout := u.ReadyTimeout
+	if timeout <= 0 {
+		timeout = DefaultUpgradeTimeout
+	}
+	readyRead.SetReadDeadline(time.Now().Add(timeout))
+
+	var ok [1]byte
+	if _, err := io.ReadFull(readyRead, ok[:]); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return fmt.Errorf("frodo: process %d was not ready within %s", cmd.Process.Pid, timeout)
+		}
+		return fmt.Errorf("frodo: process %d exited before it was ready", cmd.Process.Pid)
+	}
+
+	// the new process outlives this one, reap it should it exit first
+	go cmd.Wait()
+	logf(u.Logger, "[LOG] Upgraded, process %d is ready", cmd.Process.Pid)
+	return nil
+}
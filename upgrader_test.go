@@ -0,0 +1,151 @@
+package frodo
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// upgradeTestAddr is the address both processes listen on, the key
+// the listener is handed over under
+const upgradeTestAddr = "127.0.0.1:0"
+
+// TestMain runs the test binary as the new process when an Upgrader started it
+func TestMain(m *testing.M) {
+	if os.Getenv(upgradeReadyEnv) != "" {
+		os.Exit(upgradedProcess())
+	}
+	os.Exit(m.Run())
+}
+
+// upgradedProcess serves on the listener handed over, answering "child",
+// until it is asked to quit
+func upgradedProcess() int {
+	upgrader, err := NewUpgrader()
+	if err != nil {
+		return 1
+	}
+	defer upgrader.Stop()
+	if !upgrader.HasParent() {
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	app := New()
+	app.Get("/", func(w http.ResponseWriter, r *Request) {
+		io.WriteString(w, "child")
+	})
+	app.Get("/quit", func(w http.ResponseWriter, r *Request) {
+		io.WriteString(w, "bye")
+		cancel()
+	})
+	if err := app.Run(ctx, Server{Addr: upgradeTestAddr, Upgrader: upgrader}); err != nil {
+		return 1
+	}
+	return 0
+}
+
+func TestUpgraderHandsOverListener(t *testing.T) {
+	upgrader, err := NewUpgrader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upgrader.Stop()
+	upgrader.ReadyTimeout = 10 * time.Second
+
+	started, release := make(chan struct{}), make(chan struct{})
+	app := New()
+	app.Get("/", func(w http.ResponseWriter, r *Request) {
+		io.WriteString(w, "parent")
+	})
+	app.Get("/slow", func(w http.ResponseWriter, r *Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "parent")
+	})
+
+	addrs := make(chan net.Addr, 1)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- app.Run(context.Background(), Server{
+			Addr:     upgradeTestAddr,
+			Upgrader: upgrader,
+			Ready:    func(addr net.Addr) { addrs <- addr },
+		})
+	}()
+	var base string
+	select {
+	case addr := <-addrs:
+		base = "http://" + addr.String()
+	case err := <-stopped:
+		t.Fatal(err)
+	}
+
+	// every request on a new connection, so it is accepted by whoever is listening
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 10 * time.Second}
+	get := func(path string) (string, error) {
+		res, err := client.Get(base + path)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		return string(body), err
+	}
+
+	if body, err := get("/"); err != nil || body != "parent" {
+		t.Fatalf("before the upgrade got %q, %v", body, err)
+	}
+
+	inFlight := make(chan string, 1)
+	go func() {
+		body, err := get("/slow")
+		if err != nil {
+			body = err.Error()
+		}
+		inFlight <- body
+	}()
+	<-started
+
+	if err := upgrader.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-upgrader.Exit():
+	default:
+		t.Fatal("Exit is not closed after the upgrade")
+	}
+	if err := upgrader.Upgrade(); err != ErrUpgradeInProgress {
+		t.Fatalf("a second Upgrade returned %v", err)
+	}
+
+	// the old process waits on the request it is serving
+	select {
+	case err := <-stopped:
+		t.Fatalf("Run returned with a request in flight: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(release)
+	if body := <-inFlight; body != "parent" {
+		t.Fatalf("the in-flight request got %q", body)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatalf("the old process did not shut down cleanly: %v", err)
+	}
+
+	// the listener outlived the old process' server
+	for i := 0; i < 3; i++ {
+		if body, err := get("/"); err != nil || body != "child" {
+			t.Fatalf("after the upgrade got %q, %v", body, err)
+		}
+	}
+	if body, err := get("/quit"); err != nil || body != "bye" {
+		t.Fatalf("quitting the new process got %q, %v", body, err)
+	}
+}
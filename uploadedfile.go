@@ -1,15 +1,15 @@
 package frodo
 
 import (
-	"fmt"
+	"context"
 	"io"
 	"mime/multipart"
-	"os"
 	"path/filepath"
-	"reflect"
+	"strings"
 )
 
-// FileUploadsPath for now, declares the path to upload the files
+// FileUploadsPath declares the directory files are uploaded to
+// when neither the Router nor the call to Move names a Storage
 var FileUploadsPath = "./assets/uploads/"
 
 // UploadedFile struct/type is the data that makes up an uploaded file
@@ -23,6 +23,10 @@ type UploadedFile struct {
 	       Header   textproto.MIMEHeader
 	   }
 	*/
+	storage   Storage
+	naming    NamingStrategy
+	collision CollisionPolicy
+	ctx       context.Context
 }
 
 // Name returns the name of the file when it was uploaded
@@ -31,68 +35,145 @@ func (file *UploadedFile) Name() string {
 	return file.Filename
 }
 
-// Size returns the size of the file in question
+// Size returns the size of the file in question, as received by the server
 func (file *UploadedFile) Size() int64 {
-	defer file.Close()
-	return file.Size()
+	return file.FileHeader.Size
 }
 
-// Extension returns the extension of the file uploaded
+// Extension returns the extension of the file uploaded, in lower case
 func (file *UploadedFile) Extension() string {
 	// _, header, error := r.FormFile(name)
-	ext := filepath.Ext(file.Filename)
-	return ext
+	ext := filepath.Ext(SanitizeFilename(file.Filename))
+	return strings.ToLower(ext)
 }
 
-// Move basically moves/transfers the uploaded file to the upload folder provided
+// Move basically moves/transfers the uploaded file into a Storage, returning
+// a description of the stored file.
 //
 // Using ...interface{} because I want the user to only pass more than one argument
-// when changing upload dir and filename, if none is changed then defaults  are used
+// when changing the storage or filename, if none is changed then defaults are used.
+// The defaults are the Router's Storage, or the FileUploadsPath directory.
+// Unless a name is given, the NamingStrategy picks one, by default the client's
+// file name, sanitized. A NamingStrategy or CollisionPolicy can also be passed in.
 //
 //    eg. file.Move(true)
 //        ----- or -----
 //        file.Move("../new_upload_path/", "newfilename.png")
+//        ----- or -----
+//        file.Move(frodo.NewMemoryStorage(), "newfilename.png")
+//        ----- or -----
+//        file.Move(frodo.RandomName, frodo.FailOnCollision)
 //
-func (file *UploadedFile) Move(args ...interface{}) bool {
-	file.Open()
+func (file *UploadedFile) Move(args ...interface{}) (*StoredFile, error) {
 	defer file.Close()
-	name := args[0]
-	val := reflect.ValueOf(name)
 
-	// If a string was give, then treat is a the FileUploadsPath
-	if val.Kind().String() == "string" {
-		FileUploadsPath = name.(string)
+	target, err := file.target(args)
+	if err != nil {
+		return nil, err
+	}
+	return file.store(target)
+}
+
+// moveTarget is where a file is moved to, and what happens when the key is taken
+type moveTarget struct {
+	storage   Storage
+	key       string
+	collision CollisionPolicy
+}
+
+// target works out from the arguments to Move where the file goes
+func (file *UploadedFile) target(args []interface{}) (moveTarget, error) {
+	storage := file.storage
+	naming := file.naming
+	collision := file.collision
+	var strs []string
+	var named bool
+	for _, arg := range args {
+		switch value := arg.(type) {
+		case Storage:
+			storage = value
+			named = true
+		case string:
+			strs = append(strs, value)
+		case NamingStrategy:
+			naming = value
+		case func(*UploadedFile) (string, error):
+			naming = value
+		case CollisionPolicy:
+			collision = value
+		}
 	}
 
+	// Given a Storage the string is the file name, otherwise the 1st
+	// string is a directory to upload to, for this move only, and the 2nd the file name
 	var FileName string
-	// Check to see if a file name was given, 2nd argument
-	if len(args) > 1 {
-		FileName = args[1].(string)
-	} else {
-		FileName = file.Name()
+	if !named && len(strs) > 0 {
+		storage = NewLocalStorage(strs[0], "")
+		strs = strs[1:]
+	}
+	if len(strs) > 0 {
+		FileName = strs[0]
+	}
+	if storage == nil {
+		storage = NewLocalStorage(FileUploadsPath, "")
+	}
+	if naming == nil {
+		naming = KeepOriginalName
 	}
 
-	savedFile, err := os.OpenFile(FileUploadsPath+FileName, os.O_WRONLY|os.O_CREATE, 0666)
-	if err != nil {
-		fmt.Println(err)
-		return false
+	var err error
+	if FileName == "" {
+		if FileName, err = naming(file); err != nil {
+			return moveTarget{}, err
+		}
 	}
+	return moveTarget{storage: storage, key: FileName, collision: collision}, nil
+}
 
-	_, ioerr := io.Copy(savedFile, file)
-	if ioerr != nil {
-		fmt.Println(ioerr)
-		return false
+// store puts the file in the target's storage
+func (file *UploadedFile) store(target moveTarget) (*StoredFile, error) {
+	if _, err := file.File.Seek(0, io.SeekStart); err != nil {
+		return nil, err
 	}
+	return storeFile(file.context(), target.storage, target.key, file.File, file.MimeType(), target.collision)
+}
 
-	return true
+// context returns the context of the request the file was uploaded with
+func (file *UploadedFile) context() context.Context {
+	if file.ctx == nil {
+		return context.Background()
+	}
+	return file.ctx
 }
 
-// MimeType returns the mime/type of the file uploaded
+// MimeType returns the mime/type of the file uploaded, sniffed from its content.
+// If the content cannot be read the type the client claimed is returned.
 func (file *UploadedFile) MimeType() string {
+	mimetype, err := file.DetectMimeType()
+	if err != nil {
+		return file.ClientMimeType()
+	}
+	return mimetype
+}
+
+// ClientMimeType returns the mime/type the client claimed the file has,
+// it cannot be trusted
+func (file *UploadedFile) ClientMimeType() string {
 	mimetype := file.Header.Get("Content-Type")
 	return mimetype
 }
 
+// DetectMimeType sniffs the mime/type from the first bytes of the file,
+// without moving the file's read offset
+func (file *UploadedFile) DetectMimeType() (string, error) {
+	head := make([]byte, 512)
+	n, err := file.File.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return DetectContentType(head[:n]), nil
+}
+
 // IsValid checks if the file is alright by opening it up
 // if errors come up while opening it is an invalid upload
 func (file *UploadedFile) IsValid() bool {
@@ -0,0 +1,248 @@
+package frodo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// defaultMaxMemory is how much of a multipart form is held in memory,
+// the rest is written to temporary files
+const defaultMaxMemory = 32 << 20
+
+// magicNumbers are the signatures of common formats http.DetectContentType does not know
+var magicNumbers = []struct {
+	offset   int
+	sig      string
+	mimetype string
+}{
+	{0, "II*\x00", "image/tiff"},
+	{0, "MM\x00*", "image/tiff"},
+	{0, "8BPS", "image/vnd.adobe.photoshop"},
+	{0, "7z\xbc\xaf\x27\x1c", "application/x-7z-compressed"},
+	{0, "BZh", "application/x-bzip2"},
+	{0, "\xfd7zXZ\x00", "application/x-xz"},
+	{0, "\x00asm", "application/wasm"},
+	{4, "ftypheic", "image/heic"},
+	{4, "ftypheix", "image/heic"},
+	{4, "ftypmif1", "image/heif"},
+	{4, "ftypavif", "image/avif"},
+}
+
+// DetectContentType sniffs the mime/type of the data, using the magic numbers of
+// common formats before falling back to http.DetectContentType.
+// At most the first 512 bytes are looked at.
+func DetectContentType(data []byte) string {
+	if len(data) > 512 {
+		data = data[:512]
+	}
+
+	for _, magic := range magicNumbers {
+		if len(data) >= magic.offset+len(magic.sig) &&
+			string(data[magic.offset:magic.offset+len(magic.sig)]) == magic.sig {
+			return magic.mimetype
+		}
+	}
+
+	detected := http.DetectContentType(data)
+	switch {
+	case detected == "application/zip":
+		// office documents are zip archives
+		switch {
+		case bytes.Contains(data, []byte("word/")):
+			return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+		case bytes.Contains(data, []byte("xl/")):
+			return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+		case bytes.Contains(data, []byte("ppt/")):
+			return "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+		}
+	case strings.HasPrefix(detected, "text/xml"), strings.HasPrefix(detected, "text/plain"):
+		if bytes.Contains(data, []byte("<svg")) {
+			return "image/svg+xml"
+		}
+	}
+	return detected
+}
+
+// UploadRule lists what the files uploaded under a form field must look like
+type UploadRule struct {
+	// Field is the name of the form field the files are uploaded under
+	Field string
+
+	// Required fails the rule when no file was uploaded
+	Required bool
+
+	// MaxFiles caps how many files can be uploaded under the field, zero means no limit
+	MaxFiles int
+
+	// MinSize and MaxSize bound the size of each file in bytes, zero means no limit
+	MinSize, MaxSize int64
+
+	// AllowTypes and DenyTypes are mime/types matched against the sniffed type of
+	// the content, not the one the client claims. Wildcards like "image/*" work.
+	AllowTypes, DenyTypes []string
+
+	// AllowExtensions and DenyExtensions are matched against the file's extension, eg. ".png"
+	AllowExtensions, DenyExtensions []string
+}
+
+// UploadErrors maps form fields to the problems found with the files uploaded under them
+type UploadErrors map[string][]string
+
+func (e UploadErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var msgs []string
+	for _, field := range fields {
+		msgs = append(msgs, field+": "+strings.Join(e[field], ", "))
+	}
+	return "frodo: invalid uploads: " + strings.Join(msgs, "; ")
+}
+
+// Check validates a single file against the rule, returning what is wrong with it
+func (rule UploadRule) Check(file *UploadedFile) []string {
+	var problems []string
+	name := SanitizeFilename(file.Name())
+
+	size := file.Size()
+	if rule.MaxSize > 0 && size > rule.MaxSize {
+		problems = append(problems, fmt.Sprintf("%s is %d bytes, larger than the %d allowed", name, size, rule.MaxSize))
+	}
+	if rule.MinSize > 0 && size < rule.MinSize {
+		problems = append(problems, fmt.Sprintf("%s is %d bytes, smaller than the %d required", name, size, rule.MinSize))
+	}
+
+	if len(rule.AllowTypes) > 0 || len(rule.DenyTypes) > 0 {
+		detected, err := file.DetectMimeType()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s could not be read", name))
+		} else {
+			mimetype, _, _ := mime.ParseMediaType(detected)
+			if len(rule.AllowTypes) > 0 && !matchMimeType(mimetype, rule.AllowTypes) {
+				problems = append(problems, fmt.Sprintf("%s is of type %s, which is not allowed", name, mimetype))
+			} else if matchMimeType(mimetype, rule.DenyTypes) {
+				problems = append(problems, fmt.Sprintf("%s is of type %s, which is not allowed", name, mimetype))
+			}
+		}
+	}
+
+	ext := file.Extension()
+	if len(rule.AllowExtensions) > 0 && !matchExtension(ext, rule.AllowExtensions) {
+		problems = append(problems, fmt.Sprintf("%s does not have one of the extensions %s", name, strings.Join(rule.AllowExtensions, ", ")))
+	} else if matchExtension(ext, rule.DenyExtensions) {
+		problems = append(problems, fmt.Sprintf("%s has the extension %s, which is not allowed", name, ext))
+	}
+
+	return problems
+}
+
+// ValidateUploads checks the files sent with the request against the rules,
+// nil is returned when they all pass
+func (r *Request) ValidateUploads(rules ...UploadRule) UploadErrors {
+	if r.MultipartForm == nil {
+		r.ParseMultipartForm(defaultMaxMemory)
+	}
+
+	errs := UploadErrors{}
+	for _, rule := range rules {
+		var headers = r.uploadedHeaders(rule.Field)
+
+		if rule.Required && len(headers) == 0 {
+			errs[rule.Field] = append(errs[rule.Field], "a file is required")
+			continue
+		}
+		if rule.MaxFiles > 0 && len(headers) > rule.MaxFiles {
+			errs[rule.Field] = append(errs[rule.Field], fmt.Sprintf("at most %d files can be uploaded", rule.MaxFiles))
+		}
+
+		for _, header := range headers {
+			file, err := header.Open()
+			if err != nil {
+				errs[rule.Field] = append(errs[rule.Field], SanitizeFilename(header.Filename)+" could not be read")
+				continue
+			}
+			problems := rule.Check(r.uploadedFile(file, header))
+			file.Close()
+			errs[rule.Field] = append(errs[rule.Field], problems...)
+		}
+
+		if len(errs[rule.Field]) == 0 {
+			delete(errs, rule.Field)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// ValidateUploads is middleware checking the files sent with the request against the rules.
+// When a file fails, the request is answered with 422 Unprocessable Entity and a JSON body
+// listing the problems by field, otherwise the next handler is called.
+//
+//	r.Post("/avatars", frodo.ValidateUploads(frodo.UploadRule{
+//		Field:      "avatar",
+//		Required:   true,
+//		MaxSize:    2 << 20,
+//		AllowTypes: []string{"image/png", "image/jpeg"},
+//	}), storeAvatar)
+func ValidateUploads(rules ...UploadRule) Handler {
+	return func(w http.ResponseWriter, r *Request) {
+		if errs := r.ValidateUploads(rules...); errs != nil {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(map[string]UploadErrors{"errors": errs})
+			return
+		}
+		r.Next()
+	}
+}
+
+// uploadedHeaders returns the headers of the files uploaded under the field
+func (r *Request) uploadedHeaders(field string) []*multipart.FileHeader {
+	if r.MultipartForm == nil {
+		return nil
+	}
+	return r.MultipartForm.File[field]
+}
+
+// matchMimeType checks the mime/type against a list that may hold wildcards, eg. "image/*"
+func matchMimeType(mimetype string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == mimetype || pattern == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mimetype, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchExtension checks the extension against a list, with or without their leading dots
+func matchExtension(ext string, extensions []string) bool {
+	if ext == "" {
+		return false
+	}
+	for _, allowed := range extensions {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if !strings.HasPrefix(allowed, ".") {
+			allowed = "." + allowed
+		}
+		if allowed == ext {
+			return true
+		}
+	}
+	return false
+}
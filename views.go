@@ -0,0 +1,292 @@
+package frodo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CSRFFieldName is the name of the hidden form field
+// the csrfField template helper renders the request's token into
+const CSRFFieldName = "_csrf"
+
+// Views loads html/template pages from a directory or fs.FS and renders them.
+//
+// A page is looked up by its path without the extension, eg. "posts/show"
+// is read from "posts/show.html". Every file in PartialsDir is parsed along with
+// the page and can be included by its path, eg. {{template "partials/nav" .}}.
+// When a layout is used, the layout file found in LayoutsDir is executed and the
+// page fills in the blocks it declares:
+//
+//	layouts/app.html:  <main>{{block "content" .}}{{end}}</main>
+//	posts/show.html:   {{define "content"}}<h1>{{.Title}}</h1>{{end}}
+//
+// Parsed templates are cached, set Reload during development
+// to pick up changes made to the files without restarting.
+type Views struct {
+	// FS is the file system the templates are read from
+	FS fs.FS
+
+	// Extension of the template files, defaults to ".html"
+	Extension string
+
+	// LayoutsDir and PartialsDir are relative to FS,
+	// they default to "layouts" and "partials"
+	LayoutsDir, PartialsDir string
+
+	// Layout is the default layout pages are rendered in,
+	// leave it empty to render pages on their own
+	Layout string
+
+	// Reload re-parses a page whenever one of its files changes on disk
+	Reload bool
+
+	// Funcs are extra template helpers made available to every page
+	Funcs template.FuncMap
+
+	// Router is used by the route helper to build URLs of named routes.
+	// Request.Render falls back to the Router serving the request.
+	Router *Router
+
+	mu    sync.RWMutex
+	cache map[string]*view
+}
+
+// view is a parsed page together with the files it was made from
+type view struct {
+	tmpl      *template.Template
+	entry     string
+	signature string
+}
+
+// NewViews returns Views reading templates from the given directory
+func NewViews(dir string) *Views {
+	return NewViewsFS(os.DirFS(dir))
+}
+
+// NewViewsFS returns Views reading templates from the given fs.FS, eg. an embed.FS
+func NewViewsFS(fsys fs.FS) *Views {
+	return &Views{
+		FS:          fsys,
+		Extension:   ".html",
+		LayoutsDir:  "layouts",
+		PartialsDir: "partials",
+	}
+}
+
+// Render executes the page with the given name using the default layout
+func (v *Views) Render(w io.Writer, name string, data interface{}) error {
+	return v.RenderLayout(w, v.Layout, name, data)
+}
+
+// RenderLayout executes the page with the given name inside the given layout,
+// an empty layout renders the page on its own
+func (v *Views) RenderLayout(w io.Writer, layout, name string, data interface{}) error {
+	return v.render(w, layout, name, data, v.helpers(v.Router, nil))
+}
+
+// Render writes the page with the given name back to the client, using the
+// Router's Views. Within the page the csrfField, csrfToken, old and flashes
+// helpers are bound to this request.
+func (r *Request) Render(name string, data interface{}) error {
+	if r.router == nil || r.router.Views == nil {
+		return errors.New("frodo: no Views have been set on the Router")
+	}
+	v := r.router.Views
+
+	router := v.Router
+	if router == nil {
+		router = r.router
+	}
+
+	var buf bytes.Buffer
+	if err := v.render(&buf, v.Layout, name, data, v.helpers(router, r)); err != nil {
+		return err
+	}
+
+	if r.writer.Header().Get("Content-Type") == "" {
+		r.writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	_, err := buf.WriteTo(r.writer)
+	return err
+}
+
+// helpers returns the built in template functions bound to the router and request given,
+// either of which may be nil
+func (v *Views) helpers(router *Router, r *Request) template.FuncMap {
+	token := func() string {
+		if r == nil {
+			return ""
+		}
+		return r.CSRFToken()
+	}
+
+	return template.FuncMap{
+		"route": func(name string, pairs ...interface{}) (string, error) {
+			if router == nil {
+				return "", fmt.Errorf("frodo: cannot build the route %q without a Router", name)
+			}
+			return router.URL(name, pairs...)
+		},
+		"csrfToken": token,
+		"old": func(name string, fallback ...string) string {
+			if r == nil || r.router == nil || r.router.Sessions == nil {
+				if len(fallback) > 0 {
+					return fallback[0]
+				}
+				return ""
+			}
+			return r.Old(name, fallback...)
+		},
+		"flashes": func() map[string][]string {
+			if r == nil || r.router == nil || r.router.Sessions == nil {
+				return nil
+			}
+			return r.Flashes()
+		},
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + CSRFFieldName +
+				`" value="` + template.HTMLEscapeString(token()) + `">`)
+		},
+	}
+}
+
+// render executes a clone of the cached page so that the helpers
+// can be rebound for every call without reparsing
+func (v *Views) render(w io.Writer, layout, name string, data interface{}, funcs template.FuncMap) error {
+	page, err := v.lookup(layout, name)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := page.tmpl.Clone()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Funcs(funcs).ExecuteTemplate(&buf, page.entry, data); err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// lookup returns the parsed page from the cache,
+// parsing it the first time or when its files have changed
+func (v *Views) lookup(layout, name string) (*view, error) {
+	key := layout + ":" + name
+
+	v.mu.RLock()
+	page, ok := v.cache[key]
+	v.mu.RUnlock()
+
+	if ok && !v.Reload {
+		return page, nil
+	}
+
+	files, err := v.files(layout, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var signature string
+	if v.Reload {
+		signature = v.signature(files)
+		if ok && page.signature == signature {
+			return page, nil
+		}
+	}
+
+	page, err = v.parse(layout, name, files)
+	if err != nil {
+		return nil, err
+	}
+	page.signature = signature
+
+	v.mu.Lock()
+	if v.cache == nil {
+		v.cache = make(map[string]*view)
+	}
+	v.cache[key] = page
+	v.mu.Unlock()
+	return page, nil
+}
+
+// files lists the partials, layout and page that make up a view, in the order they are parsed
+func (v *Views) files(layout, name string) ([]string, error) {
+	var files []string
+
+	if v.PartialsDir != "" {
+		err := fs.WalkDir(v.FS, v.PartialsDir, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return fs.SkipDir
+				}
+				return err
+			}
+			if !d.IsDir() && strings.HasSuffix(file, v.Extension) {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+
+	if layout != "" {
+		files = append(files, path.Join(v.LayoutsDir, layout)+v.Extension)
+	}
+	return append(files, name+v.Extension), nil
+}
+
+// signature sums up the size and modification times of the given files
+func (v *Views) signature(files []string) string {
+	var sig strings.Builder
+	for _, file := range files {
+		info, err := fs.Stat(v.FS, file)
+		if err != nil {
+			fmt.Fprintf(&sig, "%s:missing;", file)
+			continue
+		}
+		fmt.Fprintf(&sig, "%s:%d:%s;", file, info.Size(), info.ModTime().Format(time.RFC3339Nano))
+	}
+	return sig.String()
+}
+
+// parse reads the given files into one template set,
+// each is named by its path without the extension
+func (v *Views) parse(layout, name string, files []string) (*view, error) {
+	funcs := v.helpers(nil, nil)
+	for key, fn := range v.Funcs {
+		funcs[key] = fn
+	}
+
+	tmpl := template.New("frodo:" + name).Funcs(funcs)
+	for _, file := range files {
+		content, err := fs.ReadFile(v.FS, file)
+		if err != nil {
+			return nil, fmt.Errorf("frodo: loading view %q: %w", name, err)
+		}
+		if _, err := tmpl.New(strings.TrimSuffix(file, v.Extension)).Parse(string(content)); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := name
+	if layout != "" {
+		entry = path.Join(v.LayoutsDir, layout)
+	}
+	return &view{tmpl: tmpl, entry: entry}, nil
+}
@@ -0,0 +1,534 @@
+package frodo
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// The message types defined in RFC 6455, section 11.8
+const (
+	continuationFrame = 0
+	TextMessage       = 1
+	BinaryMessage     = 2
+	CloseMessage      = 8
+	PingMessage       = 9
+	PongMessage       = 10
+)
+
+// The close codes defined in RFC 6455, section 7.4.1
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+)
+
+// DefaultWSMaxMessageSize is the largest message, in bytes, a WSConn reads
+// unless it is changed with SetMaxMessageSize
+const DefaultWSMaxMessageSize = 1 << 20
+
+// websocketGUID is appended to the client's key to build the accept key
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrWSClosed is returned when using a WSConn that has already been closed
+var ErrWSClosed = errors.New("frodo: websocket connection closed")
+
+// CloseError is returned by ReadMessage when the peer closes the connection
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("frodo: websocket closed with code %d %s", e.Code, e.Text)
+}
+
+// WSOptions changes how the opening handshake goes
+type WSOptions struct {
+	// CheckOrigin decides if a browser on the page the Origin header names may connect.
+	// By default only pages served from the host the request was made to may, as any
+	// page a user visits could otherwise open a connection with their cookies.
+	CheckOrigin func(r *Request) bool
+}
+
+// sameOrigin allows requests without an Origin, which browsers always send,
+// and those whose Origin has the host the request was made to
+func sameOrigin(r *Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.ForwardedHost())
+}
+
+// WSHandler handles a connection once it has been upgraded to a WebSocket
+type WSHandler func(*WSConn, *Request)
+
+// WSConn is a server side WebSocket connection
+type WSConn struct {
+	conn           net.Conn
+	br             *bufio.Reader
+	maxMessageSize int64
+
+	// wmu serializes frames, mmu serializes the frames of a data message
+	wmu, mmu  sync.Mutex
+	closeSent bool
+}
+
+// WebSocket registers a WebSocket endpoint. Any handlers given before the WSHandler
+// run as middleware on the GET request before the connection is upgraded,
+// with the route's params available to them. WSOptions can be passed along too.
+//
+//	r.WebSocket("/chat/:room", auth, func(conn *frodo.WSConn, r *frodo.Request) {
+//		for {
+//			kind, msg, err := conn.ReadMessage()
+//			if err != nil {
+//				return
+//			}
+//			conn.WriteMessage(kind, msg)
+//		}
+//	})
+func (r *Router) WebSocket(path string, handlers ...interface{}) {
+	if len(handlers) == 0 {
+		panic("Error: WebSocket expects a \"func(*frodo.WSConn, *frodo.Request)\" handler in path '" + path + "'")
+	}
+
+	var handle WSHandler
+	switch h := handlers[len(handlers)-1].(type) {
+	case WSHandler:
+		handle = h
+	case func(*WSConn, *Request):
+		handle = h
+	default:
+		panic("Error: the last handler given to WebSocket must be a \"func(*frodo.WSConn, *frodo.Request)\" in path '" + path + "'")
+	}
+
+	var opts []WSOptions
+	var middleware []interface{}
+	for _, h := range handlers[:len(handlers)-1] {
+		if value, ok := h.(WSOptions); ok {
+			opts = append(opts, value)
+			continue
+		}
+		middleware = append(middleware, h)
+	}
+	r.Get(path, append(middleware, func(w http.ResponseWriter, req *Request) {
+		conn, err := req.UpgradeWebSocket(opts...)
+		if err != nil {
+			return
+		}
+		defer conn.Close(CloseNormalClosure, "")
+		handle(conn, req)
+	})...)
+}
+
+// UpgradeWebSocket performs the RFC 6455 opening handshake and hijacks the connection.
+// If the handshake fails the client has already been answered with an error.
+// Browsers on other sites are turned away with 403 Forbidden, unless the
+// WSOptions' CheckOrigin lets them in.
+func (r *Request) UpgradeWebSocket(opts ...WSOptions) (*WSConn, error) {
+	w := r.writer
+	if w == nil {
+		return nil, errors.New("frodo: UpgradeWebSocket needs a request served by the Router")
+	}
+
+	fail := func(code int, msg string) (*WSConn, error) {
+		http.Error(w, msg, code)
+		return nil, errors.New("frodo: websocket handshake failed: " + msg)
+	}
+
+	if r.Method != "GET" {
+		return fail(http.StatusMethodNotAllowed, "websocket upgrades must use GET")
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "not a websocket upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := sameOrigin
+	if len(opts) > 0 && opts[0].CheckOrigin != nil {
+		checkOrigin = opts[0].CheckOrigin
+	}
+	if !checkOrigin(r) {
+		return fail(http.StatusForbidden, "websocket origin not allowed")
+	}
+
+	netConn, brw, err := w.Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, "connection cannot be upgraded")
+	}
+	// the server's deadlines no longer apply to the connection
+	netConn.SetDeadline(time.Time{})
+
+	// the 101 is the response's header, the BeforeWriteHeader hooks get to
+	// set theirs, eg. the session's cookie
+	w.statusCode = http.StatusSwitchingProtocols
+	for _, hook := range w.hooks {
+		hook(w, http.StatusSwitchingProtocols)
+	}
+
+	accept := sha1.Sum([]byte(key + websocketGUID))
+	var res strings.Builder
+	res.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	res.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n")
+	// keep any headers the middleware set, eg. cookies
+	for name, values := range w.Header() {
+		switch http.CanonicalHeaderKey(name) {
+		case "Upgrade", "Connection", "Sec-Websocket-Accept", "Content-Type", "Content-Length":
+			continue
+		}
+		for _, value := range values {
+			res.WriteString(name + ": " + singleLine(value) + "\r\n")
+		}
+	}
+	res.WriteString("\r\n")
+
+	if _, err := netConn.Write([]byte(res.String())); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return &WSConn{
+		conn:           netConn,
+		br:             brw.Reader,
+		maxMessageSize: DefaultWSMaxMessageSize,
+	}, nil
+}
+
+// SetMaxMessageSize limits the size of the messages read, a larger message
+// closes the connection with CloseMessageTooBig
+func (c *WSConn) SetMaxMessageSize(limit int64) {
+	c.maxMessageSize = limit
+}
+
+// ReadMessage reads the next text or binary message, joining its fragments.
+// Pings are answered as they arrive. When the peer closes the connection
+// a *CloseError is returned.
+func (c *WSConn) ReadMessage() (messageType int, p []byte, err error) {
+	for {
+		fin, opcode, payload, err := c.readFrame(int64(len(p)))
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case PingMessage:
+			if err := c.WriteControl(PongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(payload)
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected a continuation frame")
+			}
+			messageType = opcode
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		}
+
+		p = append(p, payload...)
+		if fin {
+			if messageType == TextMessage && !utf8.Valid(p) {
+				return 0, nil, c.fail(CloseInvalidFramePayloadData, "invalid UTF-8 in text message")
+			}
+			return messageType, p, nil
+		}
+	}
+}
+
+// WriteMessage sends a text or binary message in a single frame
+func (c *WSConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("frodo: %d is not a websocket data message type", messageType)
+	}
+	c.mmu.Lock()
+	defer c.mmu.Unlock()
+	return c.writeFrame(true, messageType, data)
+}
+
+// NextWriter returns a writer that sends every Write as a fragment of one message,
+// the message ends when the writer is closed
+func (c *WSConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return nil, fmt.Errorf("frodo: %d is not a websocket data message type", messageType)
+	}
+	c.mmu.Lock()
+	return &wsFragmentWriter{conn: c, opcode: messageType}, nil
+}
+
+// WriteControl sends a ping, pong or close frame,
+// control frames can be sent in between the fragments of a message
+func (c *WSConn) WriteControl(messageType int, data []byte) error {
+	if messageType != PingMessage && messageType != PongMessage && messageType != CloseMessage {
+		return fmt.Errorf("frodo: %d is not a websocket control message type", messageType)
+	}
+	if len(data) > 125 {
+		return errors.New("frodo: websocket control frames carry at most 125 bytes")
+	}
+	return c.writeFrame(true, messageType, data)
+}
+
+// Ping sends a ping, the peer answers with a pong carrying the same data
+func (c *WSConn) Ping(data []byte) error {
+	return c.WriteControl(PingMessage, data)
+}
+
+// Close sends a close frame with the given code and reason, then closes the connection
+func (c *WSConn) Close(code int, reason string) error {
+	err := c.sendClose(code, reason)
+	c.conn.Close()
+	if err == ErrWSClosed {
+		return nil
+	}
+	return err
+}
+
+// SetReadDeadline sets the deadline for reading the next message
+func (c *WSConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for writing frames
+func (c *WSConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// RemoteAddr returns the address of the client
+func (c *WSConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// handleClose answers the peer's close frame and reports it as a *CloseError
+func (c *WSConn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close frame")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(closeErr.Text) {
+			return c.fail(CloseInvalidFramePayloadData, "invalid UTF-8 in close reason")
+		}
+	}
+
+	code := closeErr.Code
+	if code == CloseNoStatusReceived {
+		code = CloseNormalClosure
+	}
+	c.sendClose(code, "")
+	c.conn.Close()
+	return closeErr
+}
+
+// fail closes the connection because the peer broke the protocol
+func (c *WSConn) fail(code int, reason string) error {
+	c.Close(code, reason)
+	return &CloseError{Code: code, Text: reason}
+}
+
+// sendClose writes the close frame, only ever once
+func (c *WSConn) sendClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+	return c.writeFrame(true, CloseMessage, payload)
+}
+
+// readFrame reads a single frame, unmasking its payload.
+// read is the size of the message read so far, used to enforce the size limit.
+func (c *WSConn) readFrame(read int64) (fin bool, opcode int, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+
+	fin = head[0]&0x80 != 0
+	opcode = int(head[0] & 0x0f)
+	masked := head[1]&0x80 != 0
+	length := int64(head[1] & 0x7f)
+
+	if head[0]&0x70 != 0 {
+		return fin, opcode, nil, c.fail(CloseProtocolError, "reserved bits set without an extension")
+	}
+	if !masked {
+		return fin, opcode, nil, c.fail(CloseProtocolError, "client frames must be masked")
+	}
+
+	control := opcode >= CloseMessage
+	switch opcode {
+	case continuationFrame, TextMessage, BinaryMessage, CloseMessage, PingMessage, PongMessage:
+	default:
+		return fin, opcode, nil, c.fail(CloseProtocolError, "unknown opcode")
+	}
+	if control && (!fin || length > 125) {
+		return fin, opcode, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+		if length < 0 {
+			return fin, opcode, nil, c.fail(CloseProtocolError, "invalid frame length")
+		}
+	}
+
+	if !control && c.maxMessageSize > 0 && read+length > c.maxMessageSize {
+		return fin, opcode, nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame sends a single unmasked frame, nothing is sent once a close frame has gone out
+func (c *WSConn) writeFrame(fin bool, opcode int, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closeSent {
+		return ErrWSClosed
+	}
+
+	frame := make([]byte, 0, 10+len(payload))
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	frame = append(frame, first)
+
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	frame = append(frame, payload...)
+
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// wsFragmentWriter sends a message as a series of fragments
+type wsFragmentWriter struct {
+	conn   *WSConn
+	opcode int
+	closed bool
+}
+
+func (w *wsFragmentWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrWSClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.conn.writeFrame(false, w.opcode, p); err != nil {
+		return 0, err
+	}
+	// every fragment after the first is a continuation
+	w.opcode = continuationFrame
+	return len(p), nil
+}
+
+func (w *wsFragmentWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.conn.mmu.Unlock()
+	return w.conn.writeFrame(true, w.opcode, nil)
+}
+
+// validCloseCode checks a close code received from the peer may be used on the wire
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code == CloseNoStatusReceived || code == CloseAbnormalClosure || code == 1015:
+		return false
+	case code >= 1000 && code <= 1011:
+		return code != 1004
+	}
+	return false
+}
+
+// headerHasToken checks a comma separated header for the given token, ignoring case
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
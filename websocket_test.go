@@ -0,0 +1,292 @@
+package frodo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsClient is the client side of a WebSocket connection, just enough of
+// RFC 6455 to talk to the server frame by frame
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dialWS sends the opening handshake with the headers given, returning
+// the server's answer and, when it switched protocols, the client
+func dialWS(t *testing.T, server *httptest.Server, path string, header map[string]string) (*http.Response, *wsClient) {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	handshake := map[string]string{
+		"Host":                  server.Listener.Addr().String(),
+		"Upgrade":               "websocket",
+		"Connection":            "keep-alive, Upgrade",
+		"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+		"Sec-WebSocket-Version": "13",
+	}
+	for name, value := range header {
+		handshake[name] = value
+	}
+	var req strings.Builder
+	req.WriteString("GET " + path + " HTTP/1.1\r\n")
+	for name, value := range handshake {
+		req.WriteString(name + ": " + value + "\r\n")
+	}
+	req.WriteString("\r\n")
+	if _, err := io.WriteString(conn, req.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return res, nil
+	}
+	return res, &wsClient{t: t, conn: conn, br: br}
+}
+
+// write sends a single masked frame
+func (c *wsClient) write(fin bool, opcode int, payload []byte) {
+	c.t.Helper()
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read reads a single frame from the server, which must not be masked
+func (c *wsClient) read() (fin bool, opcode int, payload []byte) {
+	c.t.Helper()
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c.br, head); err != nil {
+		c.t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 {
+		c.t.Fatal("the server masked a frame")
+	}
+	size := uint64(head[1] & 0x7F)
+	switch size {
+	case 126:
+		ext := make([]byte, 2)
+		io.ReadFull(c.br, ext)
+		size = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		io.ReadFull(c.br, ext)
+		size = binary.BigEndian.Uint64(ext)
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return head[0]&0x80 != 0, int(head[0] & 0x0F), payload
+}
+
+// readClose reads the server's close frame, returning its code
+func (c *wsClient) readClose() int {
+	c.t.Helper()
+	_, opcode, payload := c.read()
+	if opcode != CloseMessage || len(payload) < 2 {
+		c.t.Fatalf("expected a close frame, got opcode %d %q", opcode, payload)
+	}
+	return int(binary.BigEndian.Uint16(payload))
+}
+
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+// echoServer echoes every message back, passing on the error
+// that ended the connection
+func echoServer(t *testing.T, maxMessageSize int64, opts ...interface{}) (*httptest.Server, <-chan error) {
+	ended := make(chan error, 1)
+	app := New()
+	app.WebSocket("/echo", append(opts, func(conn *WSConn, r *Request) {
+		if maxMessageSize > 0 {
+			conn.SetMaxMessageSize(maxMessageSize)
+		}
+		for {
+			kind, msg, err := conn.ReadMessage()
+			if err != nil {
+				ended <- err
+				return
+			}
+			if err := conn.WriteMessage(kind, msg); err != nil {
+				ended <- err
+				return
+			}
+		}
+	})...)
+	server := httptest.NewServer(app)
+	t.Cleanup(server.Close)
+	return server, ended
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	server, _ := echoServer(t, 0)
+
+	// the key and accept key from RFC 6455, section 1.3
+	res, client := dialWS(t, server, "/echo", nil)
+	if client == nil {
+		t.Fatalf("the handshake was answered with %s", res.Status)
+	}
+	if accept := res.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept is %q", accept)
+	}
+	if !headerHasToken(res.Header, "Upgrade", "websocket") || !headerHasToken(res.Header, "Connection", "upgrade") {
+		t.Errorf("the 101 is missing the Upgrade and Connection headers: %v", res.Header)
+	}
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		status int
+	}{
+		{"old version", map[string]string{"Sec-WebSocket-Version": "8"}, http.StatusUpgradeRequired},
+		{"short key", map[string]string{"Sec-WebSocket-Key": "c2hvcnQ="}, http.StatusBadRequest},
+		{"no upgrade", map[string]string{"Upgrade": "h2c"}, http.StatusBadRequest},
+		{"other origin", map[string]string{"Origin": "https://evil.com"}, http.StatusForbidden},
+		{"same origin", map[string]string{"Origin": "http://" + server.Listener.Addr().String()}, http.StatusSwitchingProtocols},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, _ := dialWS(t, server, "/echo", test.header)
+			if res.StatusCode != test.status {
+				t.Errorf("answered with %s, want %d", res.Status, test.status)
+			}
+			if test.status == http.StatusUpgradeRequired && res.Header.Get("Sec-WebSocket-Version") != "13" {
+				t.Errorf("the 426 does not name the version supported")
+			}
+		})
+	}
+}
+
+func TestWebSocketCheckOrigin(t *testing.T) {
+	server, _ := echoServer(t, 0, WSOptions{CheckOrigin: func(r *Request) bool {
+		return r.Header.Get("Origin") == "https://app.example.com"
+	}})
+	if res, _ := dialWS(t, server, "/echo", map[string]string{"Origin": "https://app.example.com"}); res.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("an allowed origin was answered with %s", res.Status)
+	}
+	if res, _ := dialWS(t, server, "/echo", map[string]string{"Origin": "https://evil.com"}); res.StatusCode != http.StatusForbidden {
+		t.Errorf("a refused origin was answered with %s", res.Status)
+	}
+}
+
+func TestWebSocketMessages(t *testing.T) {
+	server, ended := echoServer(t, 0)
+	_, client := dialWS(t, server, "/echo", nil)
+
+	// pings are answered with their payload
+	client.write(true, PingMessage, []byte("are you there"))
+	if _, opcode, payload := client.read(); opcode != PongMessage || string(payload) != "are you there" {
+		t.Fatalf("a ping was answered with opcode %d %q", opcode, payload)
+	}
+
+	// fragments are joined, control frames between them are answered first
+	client.write(false, TextMessage, []byte("Hel"))
+	client.write(true, PingMessage, []byte("mid"))
+	client.write(false, continuationFrame, []byte("lo, "))
+	client.write(true, continuationFrame, []byte("World"))
+	if _, opcode, payload := client.read(); opcode != PongMessage || string(payload) != "mid" {
+		t.Fatalf("the ping between fragments was answered with opcode %d %q", opcode, payload)
+	}
+	if fin, opcode, payload := client.read(); !fin || opcode != TextMessage || string(payload) != "Hello, World" {
+		t.Fatalf("echoed fin %v, opcode %d, %q", fin, opcode, payload)
+	}
+
+	client.write(true, BinaryMessage, []byte{0, 1, 2})
+	if _, opcode, payload := client.read(); opcode != BinaryMessage || string(payload) != "\x00\x01\x02" {
+		t.Fatalf("echoed opcode %d %q", opcode, payload)
+	}
+
+	// the close code is echoed and handed to the handler
+	client.write(true, CloseMessage, closePayload(CloseGoingAway, "bye"))
+	if code := client.readClose(); code != CloseGoingAway {
+		t.Errorf("the close was echoed with %d", code)
+	}
+	var closeErr *CloseError
+	if err := <-ended; !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway || closeErr.Text != "bye" {
+		t.Errorf("ReadMessage returned %v", err)
+	}
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		send  func(c *wsClient)
+		code  int
+		limit int64
+	}{
+		{"message too big", func(c *wsClient) {
+			c.write(true, BinaryMessage, make([]byte, 200))
+		}, CloseMessageTooBig, 100},
+		{"fragments too big", func(c *wsClient) {
+			c.write(false, BinaryMessage, make([]byte, 60))
+			c.write(true, continuationFrame, make([]byte, 60))
+		}, CloseMessageTooBig, 100},
+		{"invalid UTF-8", func(c *wsClient) {
+			c.write(true, TextMessage, []byte{0xFF, 0xFE})
+		}, CloseInvalidFramePayloadData, 0},
+		{"continuation without a message", func(c *wsClient) {
+			c.write(true, continuationFrame, []byte("x"))
+		}, CloseProtocolError, 0},
+		{"fragmented ping", func(c *wsClient) {
+			c.write(false, PingMessage, []byte("x"))
+		}, CloseProtocolError, 0},
+		{"invalid close code", func(c *wsClient) {
+			c.write(true, CloseMessage, closePayload(1004, ""))
+		}, CloseProtocolError, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, ended := echoServer(t, test.limit)
+			_, client := dialWS(t, server, "/echo", nil)
+			test.send(client)
+			if code := client.readClose(); code != test.code {
+				t.Errorf("closed with %d, want %d", code, test.code)
+			}
+			var closeErr *CloseError
+			if err := <-ended; !errors.As(err, &closeErr) || closeErr.Code != test.code {
+				t.Errorf("ReadMessage returned %v", err)
+			}
+		})
+	}
+}